@@ -0,0 +1,149 @@
+package fpast2l
+
+import "encoding/base64"
+
+// b64Codec selects the implementation
+// of RFC 4648 sec. 5 Base64 encoding without padding
+// used for the nonce and ciphertext of tokens.
+type b64Codec bool
+
+const (
+	stdB64 b64Codec = false // encoding/base64, see b64
+	ctB64  b64Codec = true  // constant-time, see ctEncodeB64
+)
+
+// WithConstantTimeBase64 returns a copy of Engine
+// that encodes and decodes the nonce and ciphertext of tokens
+// in constant time,
+// rather than with the table lookups of encoding/base64.
+// Tokens are the same either way.
+func (eng Engine) WithConstantTimeBase64() Engine { eng.codec = ctB64; return eng }
+
+// encode encodes src into dst, as b64.Encode would.
+func (c b64Codec) encode(dst, src []byte) {
+	if ctB64 == c {
+		ctEncodeB64(dst, src)
+	} else {
+		b64.Encode(dst, src)
+	}
+}
+
+// decode decodes s into dst, as b64.Decode would.
+func (c b64Codec) decode(dst []byte, s string) (int, error) {
+	if ctB64 == c {
+		return ctDecodeB64(dst, s)
+	}
+
+	return decodeB64(dst, s)
+}
+
+// ctEncodeB64 is b64.Encode
+// without data-dependent branches or table lookups.
+func ctEncodeB64(dst, src []byte) {
+	n := len(src) / 3 * 3
+	for i, j := 0, 0; i < n; i, j = i+3, j+4 {
+		v := uint(src[i])<<16 | uint(src[i+1])<<8 | uint(src[i+2])
+		dst[j+0] = ctEncodeChar(v >> 18 & 0x3f)
+		dst[j+1] = ctEncodeChar(v >> 12 & 0x3f)
+		dst[j+2] = ctEncodeChar(v >> 6 & 0x3f)
+		dst[j+3] = ctEncodeChar(v & 0x3f)
+	}
+
+	j := n / 3 * 4
+	switch len(src) - n {
+	case 1:
+		v := uint(src[n]) << 16
+		dst[j+0] = ctEncodeChar(v >> 18 & 0x3f)
+		dst[j+1] = ctEncodeChar(v >> 12 & 0x3f)
+	case 2:
+		v := uint(src[n])<<16 | uint(src[n+1])<<8
+		dst[j+0] = ctEncodeChar(v >> 18 & 0x3f)
+		dst[j+1] = ctEncodeChar(v >> 12 & 0x3f)
+		dst[j+2] = ctEncodeChar(v >> 6 & 0x3f)
+	}
+}
+
+// ctDecodeB64 is b64.Decode
+// without data-dependent branches or table lookups.
+// Like encoding/base64, it skips newlines;
+// only their positions affect timing.
+//
+// Invalid input is detected in constant time,
+// the offset in the returned base64.CorruptInputError is not
+// and may differ from that of encoding/base64.
+func ctDecodeB64(dst []byte, s string) (n int, err error) {
+	var (
+		acc  uint
+		bits uint
+		q    int // characters decoded, excluding newlines
+		bad  int // -1 if any character is invalid
+	)
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if '\n' == c || '\r' == c {
+			continue
+		}
+
+		v := ctDecodeChar(c)
+		bad |= v
+		acc = acc<<6 | uint(v)&0x3f
+		bits += 6
+		q++
+
+		if bits >= 8 {
+			bits -= 8
+			dst[n] = byte(acc >> bits)
+			n++
+		}
+	}
+
+	if bad < 0 || 1 == q%4 {
+		return n, corruptB64(s)
+	}
+
+	return n, nil
+}
+
+// corruptB64 returns the error for invalid base64 input s,
+// with the offset of its first invalid character
+// or len(s) if its length is invalid.
+func corruptB64(s string) error {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; '\n' != c && '\r' != c && ctDecodeChar(c) < 0 {
+			return base64.CorruptInputError(i)
+		}
+	}
+
+	return base64.CorruptInputError(len(s))
+}
+
+// ctEncodeChar returns the URL-safe base64 character for v < 64.
+//
+// The offset from 'A' is adjusted for each range of v
+// using masks derived from the sign of v minus the range bound.
+func ctEncodeChar(v uint) byte {
+	x := int(v)
+	d := int('A')
+	d += (25 - x) >> 8 & ('a' - 26 - 'A')      // v >= 26: a-z
+	d -= (51 - x) >> 8 & ('a' - 26 - '0' + 52) // v >= 52: 0-9
+	d -= (61 - x) >> 8 & ('0' - 52 - '-' + 62) // v == 62: -
+	d += (62 - x) >> 8 & ('_' - 63 - '-' + 62) // v == 63: _
+	return byte(x + d)
+}
+
+// ctDecodeChar returns the value of the URL-safe base64 character c
+// or -1 if c is not one.
+//
+// Each term is non-zero only if c is within its range,
+// in which case it is the value of c plus one.
+func ctDecodeChar(c byte) int {
+	x := int(c)
+	v := -1
+	v += ('A' - 1 - x) & (x - 'Z' - 1) >> 8 & (x - 'A' + 1)
+	v += ('a' - 1 - x) & (x - 'z' - 1) >> 8 & (x - 'a' + 27)
+	v += ('0' - 1 - x) & (x - '9' - 1) >> 8 & (x - '0' + 53)
+	v += ('-' - 1 - x) & (x - '-' - 1) >> 8 & 63
+	v += ('_' - 1 - x) & (x - '_' - 1) >> 8 & 64
+	return v
+}
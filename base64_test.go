@@ -0,0 +1,202 @@
+package fpast2l
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const b64Alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+func Test_ctDecodeChar(t *testing.T) {
+	t.Parallel()
+
+	for c := 0; c < 256; c++ {
+		exp := strings.IndexByte(b64Alphabet, byte(c))
+		if act := ctDecodeChar(byte(c)); act != exp {
+			t.Errorf("c=%#x: expected %d, actual %d", c, exp, act)
+		}
+	}
+
+	for v := uint(0); v < 64; v++ {
+		if act := ctEncodeChar(v); act != b64Alphabet[v] {
+			t.Errorf("v=%d: expected %q, actual %q", v, b64Alphabet[v], act)
+		}
+	}
+}
+
+func Test_ctEncodeB64(t *testing.T) {
+	t.Parallel()
+
+	for n := 0; n < 200; n++ {
+		b := randomBytes(make([]byte, n))
+		exp := b64.EncodeToString(b)
+
+		act := make([]byte, b64.EncodedLen(n))
+		ctEncodeB64(act, b)
+		if string(act) != exp {
+			t.Errorf("n=%d: expected %q, actual %q", n, exp, act)
+		}
+	}
+}
+
+func Test_ctDecodeB64(t *testing.T) {
+	t.Parallel()
+
+	var cases []string
+	for n := 0; n < 40; n++ {
+		s := b64.EncodeToString(randomBytes(make([]byte, n)))
+		cases = append(cases, s, s+"A", "\r\n"+s+"\n")
+
+		// every byte value at the start, middle and end
+		for c := 0; c < 256 && 0 != len(s); c++ {
+			for _, i := range [...]int{0, len(s) / 2, len(s) - 1} {
+				cases = append(cases, s[:i]+string([]byte{byte(c)})+s[i+1:])
+			}
+		}
+	}
+
+	for i, s := range cases {
+		exp := make([]byte, b64.DecodedLen(len(s)))
+		expN, expErr := b64.Decode(exp, []byte(s))
+
+		act := make([]byte, b64.DecodedLen(len(s)))
+		n, err := ctDecodeB64(act, s)
+		if (nil == err) != (nil == expErr) {
+			t.Fatalf("i=%d, s=%q: expected err = %v, actual %v", i, s, expErr, err)
+		}
+
+		if nil == err && !bytes.Equal(act[:n], exp[:expN]) {
+			t.Errorf("i=%d: expected Hex(%q), actual Hex(%q)", i,
+				hex.EncodeToString(exp[:expN]), hex.EncodeToString(act[:n]))
+		}
+	}
+}
+
+func TestEngineWithConstantTimeBase64(t *testing.T) {
+	t.Parallel()
+
+	k := randomBytes(make([]byte, KeySize))
+	f := randomString(32)
+	eng := New(k).WithFooter(f).WithConstantTimeBase64()
+
+	for i := 0; i < 64; i++ {
+		b := randomBytes(make([]byte, i*5))
+		s := eng.Encrypt(copyBuffer(b))
+
+		r, rf, err := rPASTDecrypt(k, s)
+		if nil != err {
+			t.Fatalf("i=%d: %v", i, err)
+		}
+
+		if !bytes.Equal(r, b) || rf != f {
+			t.Errorf("i=%d: expected (%x, %q), actual (%x, %q)", i, b, f, r, rf)
+		}
+
+		if r, err = eng.Decrypt(nil, rPASTEncrypt(k, b, f)); nil != err {
+			t.Fatalf("i=%d: %v", i, err)
+		}
+
+		if !bytes.Equal(r, b) {
+			t.Errorf("i=%d: expected %x, actual %x", i, b, r)
+		}
+	}
+
+	if _, err := eng.Decrypt(nil, header+strings.Repeat("*", 64)); err != ErrBadEncoding {
+		t.Errorf("expected ErrBadEncoding, actual %v", err)
+	}
+}
+
+func TestKeyringWithConstantTimeBase64(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	err := ioutil.WriteFile(path, []byte(fmt.Sprintf(
+		`{"keys": [{"id": "k0", "status": "active", "key": %q}]}`,
+		FormatKey(randomBytes(make([]byte, KeySize))))), 0600)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	w, err := WatchKeyring(path, 0, nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	w.SetConstantTimeBase64()
+	w.SetLimits(Limits{MaxTokenSize: 4 << 10})
+	kr := w.Keyring()
+	if err := w.Reload(); nil != err {
+		t.Fatal(err)
+	}
+
+	env := NewEnvelope(NewLocalKeyWrapper(randomBytes(make([]byte, KeySize)))).
+		WithLimits(Limits{MaxTokenSize: 4 << 10}).
+		WithConstantTimeBase64()
+	batch, err := env.NewBatch()
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	for i, c := range [...]struct {
+		codec b64Codec
+		eng   Engine
+	}{
+		{kr.codec, kr.keys[0].Engine},
+		{w.Keyring().codec, w.Keyring().keys[0].Engine},
+		{env.codec, batch},
+	} {
+		if ctB64 != c.codec || ctB64 != c.eng.codec {
+			t.Errorf("i=%d: expected constant-time codecs, actual (%v, %v)", i, c.codec, c.eng.codec)
+		}
+	}
+
+	b := randomBytes(make([]byte, 100))
+	s, err := w.Encrypt(copyBuffer(b))
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	if r, err := w.Decrypt(nil, s); nil != err || !bytes.Equal(r, b) {
+		t.Errorf("expected payload, actual err = %v", err)
+	}
+
+	if s, err = env.Encrypt(copyBuffer(b)); nil != err {
+		t.Fatal(err)
+	}
+
+	if r, err := env.Decrypt(nil, s); nil != err || !bytes.Equal(r, b) {
+		t.Errorf("expected payload, actual err = %v", err)
+	}
+
+	if kr.WithLimits(Limits{}).codec != ctB64 {
+		t.Error("expected WithLimits to keep the codec")
+	}
+}
+
+func BenchmarkDecodeB64ConstantTime(b *testing.B) {
+	s := b64.EncodeToString(randomBytes(make([]byte, sysPageSize)))
+	p := make([]byte, b64.DecodedLen(len(s)))
+
+	for _, c := range [...]struct {
+		name  string
+		codec b64Codec
+	}{
+		{"Std", stdB64},
+		{"ConstantTime", ctB64},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(s)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _ = c.codec.decode(p, s)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package fpast2l
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// EncryptBatch creates a PASETO v2 local token
+// from each payload in bs,
+// using up to workers goroutines
+// (runtime.GOMAXPROCS if workers is not positive)
+// that share eng.
+// Unlike Encrypt, the payloads are not modified.
+//
+// The tokens are appended to arena, which is returned as r,
+// and tokens[i] is the token of bs[i], backed by r.
+// arena is reallocated at most once,
+// if it lacks the capacity for the largest possible tokens.
+func (eng Engine) EncryptBatch(arena []byte, bs [][]byte, workers int) (r []byte, tokens [][]byte) {
+	if nil == eng.ci {
+		panic(ErrEngNotInitialized)
+	}
+
+	tokens = make([][]byte, len(bs))
+	n := 0
+	for _, b := range bs {
+		n += eng.maxTokenSize(len(b))
+	}
+
+	arena, r = extend(arena, n)
+	slots := r[len(arena):]
+	for i, b := range bs {
+		k := eng.maxTokenSize(len(b))
+		tokens[i], slots = slots[:0:k], slots[k:]
+	}
+
+	runBatch(workers, len(bs), func() func(int) {
+		e := NewEncoder(eng)
+		return func(i int) { tokens[i] = e.appendEncrypt(tokens[i], bs[i]) }
+	})
+
+	return
+}
+
+// DecryptBatch parses and decrypts each token in ss,
+// using up to workers goroutines
+// (runtime.GOMAXPROCS if workers is not positive)
+// that share eng.
+//
+// Each plaintext is appended to arena, which is returned as r,
+// and bs[i] is the plaintext of ss[i], backed by r,
+// unless decompression outgrows the space reserved for it.
+// If ss[i] cannot be decrypted,
+// bs[i] is nil and errs[i] is the error Decrypt would return.
+// arena is reallocated at most once,
+// if it lacks the capacity to decode every token,
+// and reserves no space for tokens exceeding the limits of eng.
+func (eng Engine) DecryptBatch(arena []byte, ss []string, workers int) (r []byte, bs [][]byte, errs []error) {
+	if nil == eng.ci {
+		panic(ErrEngNotInitialized)
+	}
+
+	bs, errs = make([][]byte, len(ss)), make([]error, len(ss))
+	n := 0
+	for i, s := range ss {
+		if errs[i] = eng.limits.checkToken(len(s)); nil == errs[i] {
+			n += len(s) + minPAESize // see Decoder.Decrypt
+		}
+	}
+
+	arena, r = extend(arena, n)
+	slots := r[len(arena):]
+	for i, s := range ss {
+		if nil == errs[i] {
+			k := len(s) + minPAESize
+			bs[i], slots = slots[:0:k], slots[k:]
+		}
+	}
+
+	runBatch(workers, len(ss), func() func(int) {
+		return func(i int) {
+			if nil == errs[i] {
+				bs[i], errs[i] = eng.Decrypt(bs[i], ss[i])
+			}
+		}
+	})
+
+	return
+}
+
+// maxTokenSize returns the largest size of a token of eng
+// with a payload of n bytes.
+// Compression never makes payloads larger (see deflate)
+// but may drop the deflate flag from the footer.
+func (eng Engine) maxTokenSize(n int) int {
+	if eng.pad {
+		n = paddedSize(n+1, eng.buckets)
+	}
+
+	flags := 0
+	if eng.zip {
+		flags |= flagDeflate
+	}
+
+	if eng.pad {
+		flags |= flagPad
+	}
+
+	n = headerSize + b64NonceSize + b64.EncodedLen(n+tagSize)
+	if f := eng.t.f64[flags]; 0 != len(f) {
+		n += 1 + len(f)
+	}
+
+	return n
+}
+
+// runBatch calls f(i) for every i in [0, n)
+// on up to workers goroutines
+// (runtime.GOMAXPROCS if workers is not positive),
+// each with its own f created by newWorker.
+func runBatch(workers, n int, newWorker func() func(i int)) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	if workers > n {
+		workers = n
+	}
+
+	if workers <= 1 {
+		f := newWorker()
+		for i := 0; i < n; i++ {
+			f(i)
+		}
+
+		return
+	}
+
+	var (
+		wg   sync.WaitGroup
+		next int64 = -1
+	)
+
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(f func(int)) {
+			defer wg.Done()
+			for i := int(atomic.AddInt64(&next, 1)); i < n; i = int(atomic.AddInt64(&next, 1)) {
+				f(i)
+			}
+		}(newWorker())
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,183 @@
+package fpast2l
+
+import (
+	"bytes"
+	"compress/flate"
+	"testing"
+)
+
+func TestEngineBatch(t *testing.T) {
+	t.Parallel()
+
+	k := randomBytes(make([]byte, KeySize))
+	f := randomString(32)
+
+	bs := [][]byte{nil, {0}, permissionsPayload(32)}
+	for i := 0; i < 61; i++ {
+		bs = append(bs, randomBytes(make([]byte, i*7)))
+	}
+
+	for i, eng := range [...]Engine{
+		New(k),
+		New(k).WithFooter(f),
+		New(k).WithFooter(f).WithPadding(64, 256),
+		New(k).WithCompression(flate.BestSpeed).WithPadding(),
+	} {
+		for _, workers := range [...]int{0, 1, 3, 1000} {
+			copies := make([][]byte, len(bs))
+			for j, b := range bs {
+				copies[j] = copyBuffer(b)
+			}
+
+			arena, tokens := eng.EncryptBatch(nil, copies, workers)
+			if len(tokens) != len(bs) {
+				t.Fatalf("i=%d: expected %d tokens, actual %d", i, len(bs), len(tokens))
+			}
+
+			ss := make([]string, len(tokens))
+			for j, s := range tokens {
+				if !bytes.Equal(copies[j], bs[j]) {
+					t.Errorf("i=%d,j=%d: payload was modified", i, j)
+				}
+
+				if n := eng.maxTokenSize(len(bs[j])); len(s) > n {
+					t.Errorf("i=%d,j=%d: expected len(token) <= %d, actual %d",
+						i, j, n, len(s))
+				}
+
+				if !within(arena, s) {
+					t.Errorf("i=%d,j=%d: token not backed by arena", i, j)
+				}
+
+				ss[j] = string(s)
+			}
+
+			// corrupt a few tokens
+			ss[1] = ss[1][:len(ss[1])-1]
+			ss[2] = "v1.local." + ss[2][headerSize:]
+
+			_, rs, errs := eng.DecryptBatch(nil, ss, workers)
+			for j, r := range rs {
+				switch j {
+				case 1:
+					if errs[j] != ErrBadEncryption && errs[j] != ErrBadEncoding {
+						t.Errorf("i=%d,j=%d: expected ErrBadEncryption, actual %v",
+							i, j, errs[j])
+					}
+				case 2:
+					if errs[j] != ErrBadHeader {
+						t.Errorf("i=%d,j=%d: expected ErrBadHeader, actual %v",
+							i, j, errs[j])
+					}
+				default:
+					if nil != errs[j] {
+						t.Errorf("i=%d,j=%d: %v", i, j, errs[j])
+					} else if !bytes.Equal(r, bs[j]) {
+						t.Errorf("i=%d,j=%d: expected %x, actual %x", i, j, bs[j], r)
+					}
+				}
+			}
+		}
+	}
+
+	// tokens must decrypt with the reference implementation
+	_, tokens := New(k).WithFooter(f).EncryptBatch(nil, bs, 0)
+	for j, s := range tokens {
+		r, rf, err := rPASTDecrypt(k, string(s))
+		if nil != err {
+			t.Fatalf("j=%d: %v", j, err)
+		}
+
+		if !bytes.Equal(r, bs[j]) || rf != f {
+			t.Errorf("j=%d: expected (%x, %q), actual (%x, %q)", j, bs[j], f, r, rf)
+		}
+	}
+}
+
+func TestEngineBatchArena(t *testing.T) {
+	t.Parallel()
+
+	eng := New(randomBytes(make([]byte, KeySize)))
+	bs := make([][]byte, 100)
+	for i := range bs {
+		bs[i] = randomBytes(make([]byte, 32))
+	}
+
+	arena := make([]byte, 3, 1<<16)
+	copy(arena, "abc")
+
+	r, tokens := eng.EncryptBatch(arena, bs, 4)
+	if &r[0] != &arena[0] {
+		t.Errorf("expected arena to be reused")
+	}
+
+	if string(r[:3]) != "abc" {
+		t.Errorf("expected arena contents to be kept, actual %q", r[:3])
+	}
+
+	for i, s := range tokens {
+		if !within(r, s) {
+			t.Errorf("i=%d: token not backed by arena", i)
+		}
+	}
+
+	ss := make([]string, len(tokens))
+	for i, s := range tokens {
+		ss[i] = string(s)
+	}
+
+	r, rs, _ := eng.DecryptBatch(arena[:0], ss, 4)
+	if &r[0] != &arena[0] {
+		t.Errorf("expected arena to be reused")
+	}
+
+	for i, b := range rs {
+		if !within(r, b) {
+			t.Errorf("i=%d: plaintext not backed by arena", i)
+		}
+	}
+}
+
+// within reports whether b is backed by a.
+func within(a, b []byte) bool {
+	if 0 == cap(b) || 0 == cap(a) {
+		return false
+	}
+
+	a, b = a[:cap(a)], b[:cap(b)]
+	for i := range a {
+		if &a[i] == &b[0] {
+			return i+len(b) <= len(a)
+		}
+	}
+
+	return false
+}
+
+func BenchmarkEngineBatch(b *testing.B) {
+	eng := New(randomBytes(make([]byte, KeySize)))
+	bs := make([][]byte, 1<<10)
+	for i := range bs {
+		bs[i] = randomBytes(make([]byte, 64))
+	}
+
+	b.Run("Sequential", func(b *testing.B) {
+		b.ReportAllocs()
+		buf := make([]byte, 64)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for _, p := range bs {
+				_ = eng.Encrypt(append(buf[:0], p...))
+			}
+		}
+	})
+
+	b.Run("EncryptBatch", func(b *testing.B) {
+		b.ReportAllocs()
+		var arena []byte
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			arena, _ = eng.EncryptBatch(arena[:0], bs, 0)
+		}
+	})
+}
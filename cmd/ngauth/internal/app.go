@@ -2,12 +2,15 @@ package internal
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
 	"net"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog"
-	"github.com/zrhmn/fpast2l"
 )
 
 func _() {
@@ -18,83 +21,304 @@ func _() {
 type App interface {
 	Start()
 	Stop()
+	Reload(c Config) error
 	Errs() <-chan error
 }
 
+// keyring decrypts tokens.
+// It is implemented by fpast2l.Engine and *fpast2l.KeyringWatcher.
+type keyring interface {
+	Decrypt(p []byte, s string) ([]byte, error)
+}
+
 type _App struct {
 	Config
-	net.Listener
 	http.Server
-	fpast2l.Engine
 	zerolog.Logger
 
+	// state holds the *state in use, see Reload.
+	// The Auth, Issue and PASETO fields of Config are as of NewApp.
+	state     atomic.Value
+	reloading sync.Mutex // serializes Reload and Stop
+
+	grpc      http.Server
+	admin     http.Server
+	listeners []listener
+	certs     *certWatcher
+
+	metrics   *appMetrics
+	serving   int32 // listeners serving, see checks
+	draining  int32 // set by Stop, see checks
+	unsealing int32 // shares accepted plus one while unsealing, see checks
+
 	errors chan error
 }
 
 // NewApp ...
-func NewApp(c Config) App {
+func NewApp(c Config) (App, error) {
 	app := _App{
-		errors: make(chan error),
-		Logger: zerolog.Nop(),
+		errors:  make(chan error),
+		Logger:  zerolog.Nop(),
+		metrics: newAppMetrics(),
 	}
 
-	app.Engine = fpast2l.
-		New(c.PASETO.Key[:]).
-		WithFooter(c.PASETO.Footer)
-
 	app.Server.Handler = &app // app.ServeHTTP implements http.Handler
+	app.grpc.Handler = http.HandlerFunc(app.serveCheck)
+	app.grpc.Protocols = new(http.Protocols)
+	app.grpc.Protocols.SetHTTP2(true)
+	app.grpc.Protocols.SetUnencryptedHTTP2(true)
+
+	admin := http.NewServeMux()
+	admin.HandleFunc("/healthz", app.serveHealth)
+	admin.HandleFunc("/readyz", app.serveReady)
+	admin.Handle("/metrics", app.metrics)
+
+	app.admin.Handler = admin
 
 	if nil != c.Log.Output {
 		app.Logger = zerolog.New(c.Log.Output).
 			With().Timestamp().Logger().
 			With().Str("unit", "app").Logger()
 
-		app.Logger.Level(c.Log.Level)
+		app.Logger = app.Logger.Level(c.Log.Level)
+	}
+
+	if err := c.defaults(); nil != err {
+		return nil, err
+	}
+
+	app.Config = c // for the probes served while unsealing
+
+	// load certificates before a possibly long wait for unseal
+	if 0 != len(c.TLS.CertFile) {
+		certs, err := watchCerts(c.TLS, func(err error) {
+			app.Logger.Error().Str("event", "TLS").Err(err).Send()
+		})
+		if nil != err {
+			return nil, err
+		}
+
+		app.certs = certs
+	}
+
+	if 0 == len(c.PASETO.Keyring) && 0 != len(c.PASETO.Unseal.Socket) {
+		// answer probes while waiting, see Start
+		atomic.StoreInt32(&app.unsealing, 1)
+		if err := app.listenBinds(RoleAdmin); nil != err {
+			app.closeCerts()
+			return nil, err
+		}
+
+		key, err := Unseal(
+			c.PASETO.Unseal.Socket,
+			c.PASETO.Unseal.Threshold,
+			c.PASETO.Unseal.Fingerprint,
+			func(k int) { atomic.StoreInt32(&app.unsealing, int32(k)+1) },
+			app.Logger,
+		)
+		if nil != err {
+			app.admin.Close()
+			app.closeCerts()
+			return nil, err
+		}
+
+		atomic.StoreInt32(&app.unsealing, 0)
+
+		c.PASETO.Key = key
+		zero(key[:])
+	}
+
+	s, err := app.newState(&c, nil)
+	if nil != err {
+		app.admin.Close()
+		app.closeCerts()
+		return nil, err
+	}
+
+	app.state.Store(s)
+	app.Config.PASETO.Key = c.PASETO.Key
+	return &app, nil
+}
+
+// defaults sets the unset fields of c to their defaults
+// and checks its binds.
+func (c *Config) defaults() error {
+	l := &c.PASETO.Limits
+	if 0 == l.MaxTokenSize {
+		l.MaxTokenSize = DefaultMaxTokenSize
+	}
+
+	if 0 == l.MaxFooterSize {
+		l.MaxFooterSize = DefaultMaxFooterSize
+	}
+
+	if 0 == l.MaxPayloadSize {
+		l.MaxPayloadSize = DefaultMaxPayloadSize
+	}
+
+	if 0 != len(c.TLS.CertFile) && 0 == c.TLS.ReloadInterval {
+		c.TLS.ReloadInterval = DefaultTLSReloadInterval
+	}
+
+	if 0 != len(c.PASETO.Keyring) && 0 == c.PASETO.KeyringInterval {
+		c.PASETO.KeyringInterval = 5 * time.Second
+	}
+
+	if 0 == len(c.Auth.Realm) {
+		c.Auth.Realm = DefaultRealm
+	}
+
+	if 0 == len(c.Auth.Proxy) {
+		c.Auth.Proxy = ProxyNginx
+	} else if _, ok := proxyHeaders[c.Auth.Proxy]; !ok {
+		return fmt.Errorf("auth.proxy: unknown proxy %q", c.Auth.Proxy)
+	}
+
+	for _, h := range [...]struct {
+		name *string
+		def  string
+	}{
+		{&c.Auth.Headers.Subject, DefaultSubjectHeader},
+		{&c.Auth.Headers.Scopes, DefaultScopesHeader},
+		{&c.Auth.Headers.Issuer, DefaultIssuerHeader},
+		{&c.Auth.Headers.TokenID, DefaultTokenIDHeader},
+	} {
+		if 0 == len(*h.name) {
+			*h.name = h.def
+		}
+	}
+
+	c.Auth.Claims = append([]ClaimHeader(nil), c.Auth.Claims...)
+	for i := range c.Auth.Claims {
+		if ch := &c.Auth.Claims[i]; 0 == len(ch.Separator) {
+			ch.Separator = DefaultClaimSeparator
+		}
+	}
+
+	if 0 == c.Issue.MaxTTL {
+		c.Issue.MaxTTL = DefaultMaxTTL
 	}
 
 	// set default listen config before it is consumed by app.Start
-	if 0 == len(c.Bind.Network) {
-		c.Bind.Network = "tcp"
-		if 0 == len(c.Bind.Address) {
-			c.Bind.Address = ":"
+	if 0 == len(c.Binds) {
+		c.Binds = []Bind{{Address: ":"}}
+	}
+
+	c.Binds = append([]Bind(nil), c.Binds...)
+	for i := range c.Binds {
+		b := &c.Binds[i]
+		if err := b.check(); nil != err {
+			return fmt.Errorf("bind %s: %v", b.Address, err)
+		}
+
+		if 0 == len(b.Network) {
+			b.Network = "tcp"
+		}
+
+		if 0 == len(b.Role) {
+			b.Role = RoleAuth
 		}
 	}
 
-	app.Config = c
-	return &app
+	return nil
 }
 
 func (app *_App) Start() {
-	var err error
-	app.LogEvent("INIT").Send()
+	app.LogEvent("INIT").Strs("keys", app.current().fingerprints).Send()
 
-	if app.Listener, err = net.Listen(
-		app.Config.Bind.Network,
-		app.Config.Bind.Address,
-	); nil != err {
+	// admin listeners are already serving if NewApp unsealed the key
+	roles := []string{RoleAuth, RoleGRPC, RoleAdmin}
+	if 0 != len(app.listeners) {
+		roles = roles[:2]
+	}
+
+	if err := app.listenBinds(roles...); nil != err {
+		app.admin.Close()
 		app.errors <- err
 		close(app.errors) // closing app.errors marks app termination
-		return
 	}
+}
 
-	go func() {
-		app.LogEvent("LISTEN").
-			Str("network", app.Listener.Addr().Network()).
-			Str("addr", app.Listener.Addr().String()).
-			Send()
+// listenBinds listens on the binds of app with one of roles
+// and serves them, or closes them all if any fails.
+func (app *_App) listenBinds(roles ...string) error {
+	var lns []listener
+	for _, b := range app.Config.Binds {
+		if !contains(roles, b.Role) {
+			continue
+		}
 
-		if err := app.Serve(app.Listener); err != nil {
-			if err != http.ErrServerClosed {
-				app.errors <- err
+		ln, err := app.listen(b)
+		if nil != err {
+			for _, l := range lns {
+				l.Close()
 			}
+
+			return fmt.Errorf("%s %s: %v", b.Role, b.Address, err)
 		}
 
-		app.LogEvent("CLOSE").Send()
-	}()
+		lns = append(lns, listener{ln, b})
+	}
+
+	app.listeners = append(app.listeners, lns...)
+	for _, l := range lns {
+		go app.serve(l)
+	}
+
+	return nil
+}
+
+func (app *_App) serve(l listener) {
+	app.LogEvent("LISTEN").
+		Str("role", l.Role).
+		Str("network", l.Addr().Network()).
+		Str("addr", l.Addr().String()).
+		Send()
+
+	atomic.AddInt32(&app.serving, 1)
+	err := app.server(l.Role).Serve(l)
+	atomic.AddInt32(&app.serving, -1)
+
+	if err != nil && err != http.ErrServerClosed {
+		app.errors <- err
+	}
+
+	app.LogEvent("CLOSE").Str("role", l.Role).Send()
+}
+
+// server returns the server for listeners of role.
+func (app *_App) server(role string) *http.Server {
+	switch role {
+	case RoleGRPC:
+		return &app.grpc
+	case RoleAdmin:
+		return &app.admin
+	default:
+		return &app.Server
+	}
+}
+
+// addr returns the address of the first listener of role.
+func (app *_App) addr(role string) net.Addr {
+	for _, l := range app.listeners {
+		if role == l.Role {
+			return l.Addr()
+		}
+	}
+
+	return nil
 }
 
 func (app *_App) Stop() {
 	var err error
+	// fail readiness probes first,
+	// so load balancers stop sending requests before listeners close
+	atomic.StoreInt32(&app.draining, 1)
+	if d := app.Config.DrainDelay; d > 0 {
+		app.LogEvent("DRAIN").Dur("delay", d).Send()
+		time.Sleep(d)
+	}
+
 	ctx, cfn := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cfn()
 
@@ -102,12 +326,45 @@ func (app *_App) Stop() {
 		app.errors <- err
 	}
 
-	// app.Server.Serve closes app.Listener as well.
+	if err = app.grpc.Shutdown(ctx); nil != err {
+		app.errors <- err
+	}
+
+	if err = app.admin.Shutdown(ctx); nil != err {
+		app.errors <- err
+	}
+
+	// Serve closes the listeners as well.
+
+	app.reloading.Lock()
+	app.current().close(nil, 0)
+	app.reloading.Unlock()
 
+	app.closeCerts()
 	app.LogEvent("STOP").Send()
 	close(app.errors) // closing app.errors marks app termination
 }
 
+func (app *_App) closeCerts() {
+	if nil != app.certs {
+		app.certs.Close()
+	}
+}
+
+// listen listens on b, with TLS if configured and b is not a Unix socket.
+func (app *_App) listen(b Bind) (net.Listener, error) {
+	if "unix" == b.Network {
+		return listenUnix(b)
+	}
+
+	ln, err := net.Listen(b.Network, b.Address)
+	if nil != err || nil == app.certs {
+		return ln, err
+	}
+
+	return tls.NewListener(ln, app.certs.Config()), nil
+}
+
 func (app *_App) Errs() <-chan error { return app.errors }
 
 func (app *_App) LogEvent(ev string) *zerolog.Event {
@@ -120,6 +377,12 @@ func (app *_App) LogRequest(c *core) {
 		Str("method", c.Method).
 		Str("path", c.URL.Path).
 		Str("proto", c.Proto).
+		Str("targetMethod", c.Target.Method).
+		Str("targetHost", c.Target.Host).
+		Str("targetURI", c.Target.URI).
+		Str("client", c.Target.Client).
+		Str("subject", c.Subject).
+		AnErr("reason", c.Err).
 		Int("status", c.Status).
 		Dur("reponseTime", time.Since(c.Epoch)).
 		Send()
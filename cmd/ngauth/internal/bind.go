@@ -0,0 +1,216 @@
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// listener is a listener of the app and the Bind it listens on.
+type listener struct {
+	net.Listener
+	Bind
+}
+
+// parseBinds parses a comma-separated list of binds,
+// each [role=]network:address or a TCP address,
+// such as "tcp::8080,admin=127.0.0.1:9000,grpc=unix:/run/ngauth.sock".
+func parseBinds(s string) (binds []Bind, err error) {
+	for _, spec := range strings.Split(s, ",") {
+		var b Bind
+		spec = strings.TrimSpace(spec)
+		if i := strings.IndexByte(spec, '='); i >= 0 {
+			b.Role, spec = spec[:i], spec[i+1:]
+		}
+
+		b.Address = spec
+		if i := strings.IndexByte(spec, ':'); i >= 0 {
+			switch spec[:i] {
+			case "tcp", "tcp4", "tcp6", "unix":
+				b.Network, b.Address = spec[:i], spec[i+1:]
+			}
+		}
+
+		if 0 == len(b.Address) {
+			return nil, fmt.Errorf("bind %q: missing address", spec)
+		}
+
+		binds = append(binds, b)
+	}
+
+	return
+}
+
+// check reports whether the network and role of b are supported.
+func (b *Bind) check() error {
+	switch b.Network {
+	case "", "tcp", "tcp4", "tcp6", "unix":
+	default:
+		return fmt.Errorf("unsupported network %q", b.Network)
+	}
+
+	switch b.Role {
+	case "", RoleAuth, RoleGRPC, RoleAdmin:
+	default:
+		return fmt.Errorf("unknown role %q", b.Role)
+	}
+
+	if "unix" != b.Network && (0 != b.Mode || 0 != len(b.Owner) || 0 != len(b.Group)) {
+		return fmt.Errorf("mode, owner and group only apply to unix sockets")
+	}
+
+	return nil
+}
+
+// listenUnix listens on the Unix socket b.Address,
+// replacing a stale socket file,
+// with the mode and owner of the bind.
+func listenUnix(b Bind) (net.Listener, error) {
+	return listenSocket(b.Address, func(path string) error { return chownSocket(path, b) })
+}
+
+// listenSocket listens on the Unix socket at path,
+// replacing a stale socket file.
+// The socket is created in a private directory
+// and only moved to path once setup has set its mode and owner,
+// so it is never reachable with the permissions of the umask.
+// Closing the listener removes the socket file.
+func listenSocket(path string, setup func(path string) error) (net.Listener, error) {
+	if err := removeStaleSocket(path); nil != err {
+		return nil, err
+	}
+
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".ngauth")
+	if nil != err {
+		return nil, err
+	}
+
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "s")
+
+	ln, err := net.Listen("unix", tmp)
+	if nil != err {
+		return nil, err
+	}
+
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err = setup(tmp); nil == err {
+		err = os.Rename(tmp, path)
+	}
+
+	if nil != err {
+		ln.Close()
+		return nil, err
+	}
+
+	return &socketListener{Listener: ln, path: path}, nil
+}
+
+// socketListener is a Unix socket listener moved to path,
+// which it reports as its address and removes when closed.
+type socketListener struct {
+	net.Listener
+	path string
+	once sync.Once
+}
+
+func (l *socketListener) Addr() net.Addr { return &net.UnixAddr{Name: l.path, Net: "unix"} }
+
+func (l *socketListener) Close() error {
+	err := l.Listener.Close()
+	l.once.Do(func() { os.Remove(l.path) })
+	return err
+}
+
+// chownSocket sets the mode and owner of the socket file at path
+// as configured by b.
+func chownSocket(path string, b Bind) error {
+	mode := b.Mode
+	if 0 == mode {
+		mode = DefaultSocketMode
+	}
+
+	if err := os.Chmod(path, mode); nil != err {
+		return err
+	}
+
+	uid, err := lookupID(b.Owner, func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if nil != err {
+			return "", err
+		}
+
+		return u.Uid, nil
+	})
+	if nil != err {
+		return err
+	}
+
+	gid, err := lookupID(b.Group, func(name string) (string, error) {
+		g, err := user.LookupGroup(name)
+		if nil != err {
+			return "", err
+		}
+
+		return g.Gid, nil
+	})
+	if nil != err {
+		return err
+	}
+
+	if -1 == uid && -1 == gid {
+		return nil
+	}
+
+	return os.Chown(path, uid, gid)
+}
+
+// lookupID returns the numeric ID of name, which may be numeric,
+// or -1 if name is empty.
+func lookupID(name string, lookup func(name string) (string, error)) (int, error) {
+	if 0 == len(name) {
+		return -1, nil
+	}
+
+	if id, err := strconv.Atoi(name); nil == err {
+		return id, nil
+	}
+
+	id, err := lookup(name)
+	if nil != err {
+		return -1, err
+	}
+
+	return strconv.Atoi(id)
+}
+
+// removeStaleSocket removes the socket file at path,
+// unless it is accepting connections or is not a socket.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if nil != err {
+		return err
+	}
+
+	if 0 == fi.Mode()&os.ModeSocket {
+		return fmt.Errorf("%s: file exists and is not a socket", path)
+	}
+
+	if c, err := net.DialTimeout("unix", path, time.Second); nil == err {
+		c.Close()
+		return fmt.Errorf("%s: socket is in use", path)
+	}
+
+	return os.Remove(path)
+}
@@ -0,0 +1,210 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseBinds(t *testing.T) {
+	t.Parallel()
+
+	for i, c := range [...]struct {
+		s     string
+		binds []Bind
+	}{
+		{":8080", []Bind{{Address: ":8080"}}},
+		{"tcp6:[::1]:80", []Bind{{Network: "tcp6", Address: "[::1]:80"}}},
+		{"localhost:80, admin=tcp::9000,grpc=unix:/run/a.sock", []Bind{
+			{Address: "localhost:80"},
+			{Network: "tcp", Address: ":9000", Role: RoleAdmin},
+			{Network: "unix", Address: "/run/a.sock", Role: RoleGRPC},
+		}},
+	} {
+		binds, err := parseBinds(c.s)
+		if nil != err {
+			t.Errorf("i=%d: %v", i, err)
+			continue
+		}
+
+		if len(binds) != len(c.binds) {
+			t.Errorf("i=%d: expected %v, actual %v", i, c.binds, binds)
+			continue
+		}
+
+		for j := range binds {
+			if binds[j] != c.binds[j] {
+				t.Errorf("i=%d,j=%d: expected %v, actual %v", i, j, c.binds[j], binds[j])
+			}
+		}
+	}
+}
+
+func TestAppBinds(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	sock := filepath.Join(dir, "auth.sock")
+
+	// a stale socket of a previous run
+	ln, err := net.Listen("unix", sock)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+
+	var cfg Config
+	if _, err := rand.Read(cfg.PASETO.Key[:]); nil != err {
+		t.Fatal(err)
+	}
+
+	cfg.Binds = []Bind{
+		{Network: "unix", Address: sock, Mode: 0600, Owner: strconv.Itoa(os.Getuid())},
+		{Address: "127.0.0.1:0", Role: RoleAdmin},
+	}
+
+	a, err := NewApp(cfg)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	app := a.(*_App)
+	defer startApp(t, app)()
+
+	fi, err := os.Stat(sock)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	if 0600 != fi.Mode().Perm() {
+		t.Errorf("expected mode 0600, actual %v", fi.Mode().Perm())
+	}
+
+	// idle connections must not outlive the app, see startApp
+	unix := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return new(net.Dialer).DialContext(ctx, "unix", sock)
+		},
+	}}
+	defer unix.CloseIdleConnections()
+
+	tcp := &http.Client{Transport: new(http.Transport)}
+	defer tcp.CloseIdleConnections()
+
+	for i, c := range [...]struct {
+		client *http.Client
+		url    string
+		status int
+	}{
+		{unix, "http://ngauth/auth", 401},
+		{unix, "http://ngauth/healthz", 401}, // not served by auth listeners
+		{tcp, "http://" + app.addr(RoleAdmin).String() + "/healthz", 200},
+		{tcp, "http://" + app.addr(RoleAdmin).String() + "/auth", 404},
+	} {
+		resp, err := c.client.Get(c.url)
+		if nil != err {
+			t.Fatalf("i=%d: %v", i, err)
+		}
+
+		resp.Body.Close()
+		if resp.StatusCode != c.status {
+			t.Errorf("i=%d: expected status %d, actual %d", i, c.status, resp.StatusCode)
+		}
+	}
+
+	// sockets in use and other files are left alone
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0600); nil != err {
+		t.Fatal(err)
+	}
+
+	for i, c := range [...]struct{ path, err string }{
+		{sock, "socket is in use"},
+		{file, "not a socket"},
+	} {
+		if _, err := listenUnix(Bind{Network: "unix", Address: c.path}); nil == err ||
+			!strings.Contains(err.Error(), c.err) {
+			t.Errorf("i=%d: expected error %q, actual %v", i, c.err, err)
+		}
+	}
+}
+
+func TestListenSocket(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ngauth.sock")
+
+	ln, err := listenSocket(path, func(tmp string) error {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expected no socket before setup, actual %v", err)
+		}
+
+		fi, err := os.Stat(filepath.Dir(tmp))
+		if nil != err || 0700 != fi.Mode().Perm() || dir != filepath.Dir(filepath.Dir(tmp)) {
+			t.Errorf("expected a private directory next to the socket, actual %v (%v)", fi, err)
+		}
+
+		return os.Chmod(tmp, 0640)
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	if fi, err := os.Stat(path); nil != err || 0640 != fi.Mode().Perm() {
+		t.Errorf("expected mode 0640, actual %v (%v)", fi, err)
+	}
+
+	if ln.Addr().String() != path {
+		t.Errorf("expected address %s, actual %s", path, ln.Addr())
+	}
+
+	if c, err := net.Dial("unix", path); nil != err {
+		t.Error(err)
+	} else {
+		c.Close()
+	}
+
+	ln.Close()
+	if files, err := ioutil.ReadDir(dir); nil != err || 0 != len(files) {
+		t.Errorf("expected the socket and private directory to be removed, actual %v (%v)", files, err)
+	}
+
+	if _, err := listenSocket(path, func(string) error { return errBadTarget }); errBadTarget != err {
+		t.Errorf("expected the setup error, actual %v", err)
+	}
+
+	if files, err := ioutil.ReadDir(dir); nil != err || 0 != len(files) {
+		t.Errorf("expected nothing to be left after a failed setup, actual %v (%v)", files, err)
+	}
+}
+
+// startApp starts app and returns a function
+// that stops it and waits until it terminates,
+// so that its errors are reported to t while the test runs.
+//
+// Clients must close their idle connections before stopping app:
+// a connection without requests, e.g. one dialed by http.Transport
+// in case the pending request needs it, delays Shutdown
+// for 5 seconds, as long as Stop waits.
+func startApp(t *testing.T, app *_App) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for err := range app.Errs() {
+			t.Error(err)
+		}
+	}()
+
+	app.Start()
+	return func() { app.Stop(); <-done }
+}
@@ -0,0 +1,188 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// setClaims adds the headers mapped from claims b by a to v,
+// and the claims as JSON header and body, if configured.
+// b must have been accepted by parseClaims.
+func (v *verdict) setClaims(a *Auth, b []byte) {
+	if 0 != len(a.Claims) {
+		var m map[string]interface{}
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber() // keep numbers as they are
+
+		if nil == dec.Decode(&m) {
+			for _, ch := range a.Claims {
+				e, _ := lookupClaim(m, ch.Claim)
+				if s := formatClaim(e, ch.Separator); 0 != len(s) {
+					v.Headers = append(v.Headers, header{ch.Header, percentEncode(s)})
+				}
+			}
+		}
+	}
+
+	if 0 == len(a.ClaimsHeader) && !a.ClaimsBody {
+		return
+	}
+
+	var buf bytes.Buffer
+	if nil != json.Compact(&buf, b) {
+		return
+	}
+
+	if 0 != len(a.ClaimsHeader) {
+		v.Headers = append(v.Headers, header{a.ClaimsHeader, asciiJSON(buf.Bytes())})
+	}
+
+	if a.ClaimsBody {
+		v.Body = buf.Bytes()
+	}
+}
+
+// lookupClaim returns the value at path in claim value v (see ClaimHeader).
+// Object names are matched longest first, so they may contain dots.
+func lookupClaim(v interface{}, path string) (interface{}, bool) {
+	if 0 == len(path) {
+		return v, true
+	}
+
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for i := len(path); i > 0; i = strings.LastIndexByte(path[:i], '.') {
+			e, ok := x[path[:i]]
+			if !ok {
+				continue
+			}
+
+			rest := ""
+			if i < len(path) {
+				rest = path[i+1:]
+			}
+
+			if e, ok = lookupClaim(e, rest); ok {
+				return e, true
+			}
+		}
+
+	case []interface{}:
+		name, rest := path, ""
+		if i := strings.IndexByte(path, '.'); i >= 0 {
+			name, rest = path[:i], path[i+1:]
+		}
+
+		if n, err := strconv.Atoi(name); nil == err && n >= 0 && n < len(x) {
+			return lookupClaim(x[n], rest)
+		}
+	}
+
+	return nil, false
+}
+
+// formatClaim formats claim value v for a header (see ClaimHeader).
+// Nested arrays are formatted as JSON, null as "".
+func formatClaim(v interface{}, sep string) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case json.Number:
+		return x.String()
+	case bool:
+		return strconv.FormatBool(x)
+	case []interface{}:
+		s := make([]string, 0, len(x))
+		for _, e := range x {
+			if _, ok := e.([]interface{}); ok {
+				s = append(s, compactJSON(e))
+			} else if f := formatClaim(e, sep); 0 != len(f) {
+				s = append(s, f)
+			}
+		}
+
+		return strings.Join(s, sep)
+	default:
+		return compactJSON(v)
+	}
+}
+
+// compactJSON encodes v as JSON without HTML escaping.
+func compactJSON(v interface{}) string {
+	var sb strings.Builder
+	enc := json.NewEncoder(&sb)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// asciiJSON escapes DEL and the non-ASCII characters
+// of compact JSON text b as \u sequences,
+// which makes it a valid header value.
+// Such characters only occur in strings,
+// so the result decodes to the same value.
+func asciiJSON(b []byte) string {
+	var sb strings.Builder
+	for len(b) > 0 {
+		r, n := utf8.DecodeRune(b)
+		b = b[n:]
+
+		if r < utf8.RuneSelf && 0x7f != r {
+			sb.WriteByte(byte(r))
+			continue
+		}
+
+		for _, u := range utf16.Encode([]rune{r}) {
+			fmt.Fprintf(&sb, `\u%04x`, u)
+		}
+	}
+
+	return sb.String()
+}
+
+// headerNames returns the names of the response headers
+// that a may set for authorized requests.
+func (a *Auth) headerNames() []string {
+	names := make([]string, 0, 5+len(a.Claims))
+	for _, name := range [...]string{
+		a.Headers.Subject,
+		a.Headers.Scopes,
+		a.Headers.Issuer,
+		a.Headers.TokenID,
+		a.ClaimsHeader,
+	} {
+		if 0 != len(name) && "-" != name {
+			names = append(names, name)
+		}
+	}
+
+	for _, ch := range a.Claims {
+		names = append(names, ch.Header)
+	}
+
+	return names
+}
+
+// validHeaderName reports whether s is a header field name,
+// a token (RFC 7230, sec. 3.2.6).
+func validHeaderName(s string) bool {
+	if 0 == len(s) {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`"(),/:;<=>?@[\]{}`, c) >= 0 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/zrhmn/fpast2l"
+)
+
+func TestSetClaims(t *testing.T) {
+	t.Parallel()
+
+	const b = `{"sub":"alice","n":12345678901234567890,"f":1.5e3,"ok":true,"nil":null,"empty":"",
+		"roles":["read","write"],"groups":[["a"],{"b":1},null,2],
+		"org":{"id":"o1","tags":["x","y"]},"https://example.com/roles":["admin"],
+		"name":"Zoë\r\nX-Evil: 1","html":"<a&b>"}`
+
+	for i, c := range [...]struct {
+		claim, sep string
+		value      string // "" if not set
+	}{
+		{"sub", "", "alice"},
+		{"n", "", "12345678901234567890"},
+		{"f", "", "1.5e3"},
+		{"ok", "", "true"},
+		{"nil", "", ""},
+		{"empty", "", ""},
+		{"missing", "", ""},
+		{"roles", ",", "read,write"},
+		{"roles", " ", "read write"},
+		{"roles.1", "", "write"},
+		{"roles.2", "", ""},
+		{"groups", ";", `["a"];{"b":1};2`},
+		{"org", "", `{"id":"o1","tags":["x","y"]}`},
+		{"org.id", "", "o1"},
+		{"org.tags.0", "", "x"},
+		{"https://example.com/roles", ",", "admin"},
+		{"https://example.com/roles.0", "", "admin"},
+		{"name", "", "Zo%C3%AB%0D%0AX-Evil: 1"},
+		{"html", "", "<a&b>"},
+	} {
+		var v verdict
+		v.setClaims(&Auth{Claims: []ClaimHeader{{c.claim, "X-Claim", c.sep}}}, []byte(b))
+		switch {
+		case 0 == len(c.value) && 0 != len(v.Headers):
+			t.Errorf("i=%d: expected no header, actual %q", i, v.Headers)
+		case 0 != len(c.value) && (1 != len(v.Headers) || c.value != v.Headers[0].Value):
+			t.Errorf("i=%d: expected %q, actual %q", i, c.value, v.Headers)
+		}
+	}
+
+	var v verdict
+	v.setClaims(&Auth{ClaimsHeader: "X-Claims", ClaimsBody: true}, []byte(b))
+	if 1 != len(v.Headers) {
+		t.Fatalf("expected the claims header, actual %q", v.Headers)
+	}
+
+	h := v.Headers[0].Value
+	for i := 0; i < len(h); i++ {
+		if h[i] < ' ' || h[i] > '~' {
+			t.Fatalf("expected printable ASCII, actual %q", h)
+		}
+	}
+
+	var fromHeader, fromBody, expected map[string]interface{}
+	for _, u := range [...]struct {
+		b []byte
+		m *map[string]interface{}
+	}{{[]byte(h), &fromHeader}, {v.Body, &fromBody}, {[]byte(b), &expected}} {
+		if err := json.Unmarshal(u.b, u.m); nil != err {
+			t.Fatal(err)
+		}
+	}
+
+	if fmt.Sprint(expected) != fmt.Sprint(fromHeader) || fmt.Sprint(expected) != fmt.Sprint(fromBody) {
+		t.Errorf("expected %v, actual %v and %v", expected, fromHeader, fromBody)
+	}
+}
+
+func TestAppClaimHeaders(t *testing.T) {
+	t.Parallel()
+
+	var cfg Config
+	if _, err := rand.Read(cfg.PASETO.Key[:]); nil != err {
+		t.Fatal(err)
+	}
+
+	cfg.Auth.Claims = []ClaimHeader{{Claim: "org.roles", Header: "X-Auth-Roles"}}
+	cfg.Auth.ClaimsBody = true
+
+	app, err := NewApp(cfg)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	claims := fmt.Sprintf(`{"sub":"alice","exp":%q,"org":{"roles":["a","b"]}}`,
+		time.Now().Add(time.Hour).Format(time.RFC3339))
+
+	r := httptest.NewRequest("GET", "/auth", nil)
+	r.Header.Set("Authorization", "Bearer "+fpast2l.New(cfg.PASETO.Key[:]).Encrypt([]byte(claims)))
+	w := httptest.NewRecorder()
+	app.(*_App).ServeHTTP(w, r)
+
+	for i, v := range [...]struct{ expected, actual interface{} }{
+		{200, w.Code},
+		{"a,b", w.Header().Get("X-Auth-Roles")},
+		{"alice", w.Header().Get("X-Auth-Subject")},
+		{"application/json", w.Header().Get("Content-Type")},
+		{claims, w.Body.String()},
+	} {
+		if v.expected != v.actual {
+			t.Errorf("i=%d: expected %v, actual %v", i, v.expected, v.actual)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	errMalformedClaims = errors.New("malformed claims")
+	errExpired         = errors.New("token expired")
+	errNoExpiration    = errors.New("token has no expiration")
+	errNotYetValid     = errors.New("token not yet valid")
+	errWrongAudience   = errors.New("token not issued for this audience")
+	errWrongIssuer     = errors.New("token not issued by a trusted issuer")
+
+	errMissingToken      = errors.New("missing bearer token")
+	errInsufficientScope = errors.New("insufficient scope")
+	errBadTarget         = errors.New("malformed request target")
+	errNoRule            = errors.New("no rule allows the request")
+)
+
+// claims is the JSON payload of a token:
+// the registered PASETO claims ngauth validates
+// and the scopes it authorizes requests with.
+type claims struct {
+	Issuer     string    `json:"iss"`
+	Subject    string    `json:"sub"`
+	Audience   string    `json:"aud"`
+	Expiration time.Time `json:"exp"`
+	NotBefore  time.Time `json:"nbf"`
+	IssuedAt   time.Time `json:"iat"`
+	TokenID    string    `json:"jti"`
+	Scopes     scopes    `json:"scopes"`
+}
+
+// parseClaims parses the token payload b.
+func parseClaims(b []byte) (c claims, err error) {
+	if err = json.Unmarshal(b, &c); nil != err {
+		return claims{}, errMalformedClaims
+	}
+
+	return c, nil
+}
+
+// validate checks the time-based claims of c against now,
+// allowing for leeway of clock skew,
+// and the audience and issuer against a, if configured.
+// exp is required unless a.AllowNoExp is set.
+func (c *claims) validate(now time.Time, a *Auth) error {
+	if c.Expiration.IsZero() {
+		if !a.AllowNoExp {
+			return errNoExpiration
+		}
+	} else if !now.Before(c.Expiration.Add(a.Leeway)) {
+		return errExpired
+	}
+
+	if !c.NotBefore.IsZero() && now.Before(c.NotBefore.Add(-a.Leeway)) {
+		return errNotYetValid
+	}
+
+	if 0 != len(a.Audience) && c.Audience != a.Audience {
+		return errWrongAudience
+	}
+
+	if 0 != len(a.Issuer) && c.Issuer != a.Issuer {
+		return errWrongIssuer
+	}
+
+	return nil
+}
+
+// scopes is a set of scopes,
+// encoded either as a space-separated string (RFC 6749, sec. 3.3)
+// or as an array of strings.
+type scopes []string
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *scopes) UnmarshalJSON(b []byte) error {
+	var str string
+	if err := json.Unmarshal(b, &str); nil == err {
+		*s = strings.Fields(str)
+		return nil
+	}
+
+	var arr []string
+	if err := json.Unmarshal(b, &arr); nil != err {
+		return err
+	}
+
+	*s = arr
+	return nil
+}
+
+// has reports whether s contains all of want.
+func (s scopes) has(want []string) bool {
+outer:
+	for _, w := range want {
+		for _, v := range s {
+			if v == w {
+				continue outer
+			}
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// String returns s space-separated.
+func (s scopes) String() string { return strings.Join(s, " ") }
@@ -2,6 +2,8 @@ package internal
 
 import (
 	"io"
+	"os"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/zrhmn/fpast2l"
@@ -9,6 +11,36 @@ import (
 
 const n = fpast2l.KeySize
 
+// Default token limits (see Config.PASETO.Limits).
+// Tokens are read from the Authorization header,
+// which most proxies limit to 8 KiB,
+// and their payloads are decrypted into a page-sized buffer.
+const (
+	DefaultMaxTokenSize   = 8 << 10
+	DefaultMaxFooterSize  = 1 << 10
+	DefaultMaxPayloadSize = 4 << 10
+)
+
+// Default forward-auth settings (see Auth).
+const (
+	DefaultRealm         = "ngauth"
+	DefaultSubjectHeader = "X-Auth-Subject"
+	DefaultScopesHeader  = "X-Auth-Scopes"
+	DefaultIssuerHeader  = "X-Auth-Issuer"
+	DefaultTokenIDHeader = "X-Auth-Token-Id"
+
+	DefaultClaimSeparator = ","
+)
+
+// Proxies whose forwarded request headers ngauth reads (see Auth.Proxy).
+const (
+	ProxyNginx   = "nginx"   // X-Original-Method, -Host and -URI
+	ProxyTraefik = "traefik" // X-Forwarded-Method, -Host and -Uri
+)
+
+// DefaultMaxTTL is the default lifetime of issued tokens (see Issue).
+const DefaultMaxTTL = time.Hour
+
 // Config ...
 type Config struct {
 	Log struct {
@@ -16,13 +48,201 @@ type Config struct {
 		Output io.Writer
 	}
 
-	Bind struct {
-		Network string
-		Address string
-	}
+	// Binds are the listeners of the app,
+	// a single RoleAuth listener on any TCP port if empty.
+	Binds []Bind
+
+	// DrainDelay is how long Stop fails readiness probes
+	// (GET /readyz on RoleAdmin listeners)
+	// before it shuts the listeners down.
+	DrainDelay time.Duration
+
+	// TLS, if CertFile is set, makes all TCP listeners serve TLS.
+	TLS TLS
 
 	PASETO struct {
 		Key    [n]byte
 		Footer string
+
+		// Unseal, if Socket is set, makes NewApp
+		// wait for Threshold key shares (see package shamir)
+		// to be submitted over the Unix socket at Socket
+		// and use the key they combine into instead of Key.
+		// If Fingerprint is set, shares that combine
+		// into a key with another fingerprint (as logged by NewApp)
+		// are discarded.
+		Unseal struct {
+			Socket      string
+			Threshold   int
+			Fingerprint string
+		}
+
+		// LockKey moves Key into locked memory
+		// that is excluded from core dumps (see fpast2l.LockedKey)
+		// and zeroes Key in the Config passed to NewApp.
+		LockKey bool
+
+		// Keyring is the path to a keyring file (see fpast2l.Keyring).
+		// If set, Key and Footer are ignored
+		// and the file is reloaded every KeyringInterval when it changes.
+		Keyring         string
+		KeyringInterval time.Duration
+
+		// Limits bounds the size of accepted tokens.
+		// Zero fields are set to the defaults above,
+		// negative fields disable the limit.
+		Limits fpast2l.Limits
 	}
+
+	Auth  Auth
+	Issue Issue
+}
+
+// Listener roles (see Bind).
+const (
+	RoleAuth  = "auth"  // forward-auth and POST /token
+	RoleGRPC  = "grpc"  // Envoy ext_authz Check (envoy.service.auth.v3)
+	RoleAdmin = "admin" // GET /healthz, /readyz and /metrics
+)
+
+// DefaultSocketMode is the mode of Unix socket files (see Bind).
+const DefaultSocketMode os.FileMode = 0660
+
+// Bind is a listener serving Role, RoleAuth if empty,
+// on Network ("tcp" if empty, "tcp4", "tcp6" or "unix") and Address.
+//
+// Unix sockets are created with Mode, DefaultSocketMode if zero,
+// and Owner and Group (names or IDs), if set.
+// A stale socket file left by a previous run is removed,
+// but not one that is still accepting connections.
+// Unix sockets never serve TLS.
+type Bind struct {
+	Network string      `yaml:"network"`
+	Address string      `yaml:"address"`
+	Role    string      `yaml:"role"`
+	Mode    os.FileMode `yaml:"mode"`
+	Owner   string      `yaml:"owner"`
+	Group   string      `yaml:"group"`
+}
+
+// TLS configures the certificate of TLS listeners
+// and the verification of client certificates.
+// The files are reloaded when they change, checked every ReloadInterval,
+// and on App.Reload; handshakes use the latest valid files.
+type TLS struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+
+	// ClientCAFile, if set, is a PEM bundle of the CAs
+	// that client certificates are verified against.
+	// Clients must present one unless OptionalClientCert is set.
+	// The subject common name of verified client certificates
+	// is available to Rule.Clients and Issue.Clients.
+	ClientCAFile       string `yaml:"clientCAFile"`
+	OptionalClientCert bool   `yaml:"optionalClientCert"`
+
+	// ReloadInterval is DefaultTLSReloadInterval if zero,
+	// negative values disable polling.
+	ReloadInterval time.Duration `yaml:"reloadInterval"`
+}
+
+// Auth configures how forwarded requests are authorized.
+// Tokens carry JSON claims (see claims);
+// expired, not yet valid or otherwise invalid tokens get 401,
+// valid tokens without the scopes required by Rules get 403.
+type Auth struct {
+	// Realm is sent in WWW-Authenticate challenges.
+	Realm string `yaml:"realm"`
+
+	// Audience and Issuer, if set,
+	// must equal the aud and iss claims of tokens.
+	Audience string `yaml:"audience"`
+	Issuer   string `yaml:"issuer"`
+
+	// Leeway allows for clock skew when checking exp and nbf.
+	Leeway time.Duration `yaml:"leeway"`
+
+	// AllowNoExp accepts tokens without an exp claim,
+	// which are otherwise invalid as they never expire.
+	AllowNoExp bool `yaml:"allowNoExp"`
+
+	// Proxy is the reverse proxy forwarding requests,
+	// ProxyNginx (the default) or ProxyTraefik.
+	// Only its headers are read for the target request,
+	// and it must overwrite them, as clients can send them too.
+	Proxy string `yaml:"proxy"`
+
+	// Rules are matched in order against the forwarded request;
+	// the first match determines the scopes required.
+	// Requests matching no rule only require a valid token,
+	// or are denied with 403 if DefaultDeny is set.
+	Rules       []Rule `yaml:"rules"`
+	DefaultDeny bool   `yaml:"defaultDeny"`
+
+	// Headers names the response headers
+	// that carry the identity of authorized requests.
+	// Empty names are set to the defaults, "-" omits the header.
+	Headers struct {
+		Subject string `yaml:"subject"`
+		Scopes  string `yaml:"scopes"`
+		Issuer  string `yaml:"issuer"`
+		TokenID string `yaml:"tokenID"`
+	} `yaml:"headers"`
+
+	// Claims maps claims to response headers of authorized requests.
+	Claims []ClaimHeader `yaml:"claims"`
+
+	// ClaimsHeader, if set, names a response header
+	// carrying all claims as JSON;
+	// ClaimsBody sends them as the body of forward-auth responses.
+	ClaimsHeader string `yaml:"claimsHeader"`
+	ClaimsBody   bool   `yaml:"claimsBody"`
+}
+
+// ClaimHeader maps a claim to a response header.
+// Claim is a path into the claims, with names separated by dots
+// and array elements selected by their index, e.g. "org.roles.0".
+// Names containing dots, e.g. "https://example.com/roles", match as well.
+//
+// Strings are sent as they are, numbers as they appear in the token,
+// arrays as their elements joined by Separator (default ",")
+// and objects as JSON.
+// Header values are percent-encoded (see percentEncode);
+// claims that are missing, null or empty are not sent.
+type ClaimHeader struct {
+	Claim     string `yaml:"claim"`
+	Header    string `yaml:"header"`
+	Separator string `yaml:"separator"`
+}
+
+// Issue configures token issuance by POST /token,
+// which is enabled only if Clients is not empty.
+// Clients post the claims they want as a JSON object
+// and get a token with these claims, subject to the policy below,
+// encrypted with the key the app verifies tokens with.
+type Issue struct {
+	Clients []Client `yaml:"clients"`
+
+	// MaxTTL bounds the lifetime of issued tokens:
+	// later exp claims are lowered to now plus MaxTTL,
+	// which is also the exp of tokens requested without one.
+	// Zero is set to DefaultMaxTTL.
+	MaxTTL time.Duration `yaml:"maxTTL"`
+
+	// Audiences, if set, lists the aud claims clients may request;
+	// requests must have one of them.
+	Audiences []string `yaml:"audiences"`
+
+	// Issuer, if set, replaces the iss claim of every request.
+	Issuer string `yaml:"issuer"`
+}
+
+// Client is a trusted caller of POST /token.
+// It authenticates with HTTP Basic auth as ID and Secret,
+// or, if CommonName is set, with a verified TLS client certificate
+// for that subject common name.
+type Client struct {
+	ID         string `yaml:"id"`
+	Secret     string `yaml:"secret"`
+	CommonName string `yaml:"commonName"`
 }
@@ -0,0 +1,302 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zrhmn/fpast2l"
+)
+
+// This file implements the Check method of the Envoy external
+// authorization service (envoy.service.auth.v3.Authorization)
+// as a unary gRPC call over cleartext HTTP/2,
+// using the subset of the protobuf wire format in protowire.go.
+// See https://www.envoyproxy.io/docs/envoy/latest/api-v3/service/auth/v3/external_auth.proto
+// and https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-HTTP2.md.
+
+const checkMethod = "/envoy.service.auth.v3.Authorization/Check"
+
+// maxCheckRequestSize is the largest accepted CheckRequest,
+// the default limit of gRPC servers.
+const maxCheckRequestSize = 4 << 20
+
+// checkRequestOverhead is the room for the request attributes
+// besides the token, Envoy's default header limit of 60 KiB and some.
+const checkRequestOverhead = 64 << 10
+
+// checkRequestSize returns the largest accepted CheckRequest
+// for tokens limited by l.
+func checkRequestSize(l fpast2l.Limits) int {
+	if n := checkRequestOverhead + l.MaxTokenSize; l.MaxTokenSize > 0 && n < maxCheckRequestSize {
+		return n
+	}
+
+	return maxCheckRequestSize
+}
+
+// gRPC status codes.
+const (
+	grpcOK                = 0
+	grpcInvalidArgument   = 3
+	grpcPermissionDenied  = 7
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcUnauthenticated   = 16
+)
+
+// HeaderValueOption.AppendAction OVERWRITE_IF_EXISTS_OR_ADD,
+// so clients cannot pass their own identity headers upstream.
+const overwriteIfExistsOrAdd = 2
+
+// checkRequest is the part of a CheckRequest used by Check.
+type checkRequest struct {
+	Target        target
+	Authorization string
+}
+
+// parseCheckRequest decodes CheckRequest b,
+// reading attributes.request.http.
+func parseCheckRequest(b []byte) (r checkRequest, err error) {
+	var uri string
+	err = pbMessage(b, 1, func(b []byte) error { // CheckRequest.attributes
+		return pbMessage(b, 4, func(b []byte) error { // AttributeContext.request
+			return pbMessage(b, 2, func(b []byte) error { // Request.http
+				return pbRange(b, func(f pbField) error {
+					if wireLen != f.Type {
+						return nil
+					}
+
+					switch f.Num {
+					case 2: // method
+						r.Target.Method = string(f.Bytes)
+					case 3: // headers, map<string, string>
+						return r.header(f.Bytes, 2, 2)
+					case 4: // path, including the query
+						uri = string(f.Bytes)
+					case 5: // host
+						r.Target.Host = string(f.Bytes)
+					case 13: // header_map, HeaderMap.headers
+						return pbMessage(f.Bytes, 1, func(b []byte) error {
+							return r.header(b, 2, 3) // value or raw_value
+						})
+					}
+
+					return nil
+				})
+			})
+		})
+	})
+
+	r.Target.setURI(uri)
+	return
+}
+
+// header records the Authorization header
+// if HeaderValue or map entry b has key "authorization".
+// The value is field value or, if that is empty, field raw.
+func (r *checkRequest) header(b []byte, value, raw int) error {
+	var k, v, rv string
+	err := pbRange(b, func(f pbField) error {
+		if wireLen == f.Type {
+			switch f.Num {
+			case 1:
+				k = string(f.Bytes)
+			case value:
+				v = string(f.Bytes)
+			case raw:
+				rv = string(f.Bytes)
+			}
+		}
+
+		return nil
+	})
+
+	if nil == err && strings.EqualFold(k, "Authorization") {
+		r.Authorization = firstOf(v, rv)
+	}
+
+	return err
+}
+
+// pbMessage calls fn for every field num of message b.
+func pbMessage(b []byte, num int, fn func(b []byte) error) error {
+	return pbRange(b, func(f pbField) error {
+		if num == f.Num && wireLen == f.Type {
+			return fn(f.Bytes)
+		}
+
+		return nil
+	})
+}
+
+// appendCheckResponse appends the CheckResponse for v to b:
+// an OkHttpResponse setting the identity and claim headers
+// and removing those without a value,
+// or a DeniedHttpResponse with the status and challenge of v.
+func appendCheckResponse(b []byte, a *Auth, v *verdict) []byte {
+	var st, hr []byte
+	code := grpcOK
+	switch v.Status {
+	case http.StatusOK:
+	case http.StatusForbidden:
+		code = grpcPermissionDenied
+	default:
+		code = grpcUnauthenticated
+	}
+
+	st = pbAppendVarint(st, 1, uint64(code)) // google.rpc.Status.code
+	if nil != v.Err {
+		st = pbAppendString(st, 2, v.Err.Error()) // message
+	}
+
+	b = pbAppendBytes(b, 1, st) // CheckResponse.status
+
+	if grpcOK != code {
+		hr = pbAppendBytes(hr, 1, pbAppendVarint(nil, 1, uint64(v.Status))) // status.code
+		hr = pbAppendBytes(hr, 2, headerValueOption(header{"WWW-Authenticate", v.Challenge}))
+		return pbAppendBytes(b, 2, hr) // denied_response
+	}
+
+	for _, f := range v.Headers {
+		hr = pbAppendBytes(hr, 2, headerValueOption(f)) // headers
+	}
+
+	for _, name := range a.headerNames() {
+		if !v.has(name) {
+			hr = pbAppendString(hr, 5, strings.ToLower(name)) // headers_to_remove
+		}
+	}
+
+	return pbAppendBytes(b, 3, hr) // ok_response
+}
+
+// headerValueOption encodes a HeaderValueOption that sets f.
+func headerValueOption(f header) []byte {
+	var hv []byte
+	hv = pbAppendString(hv, 1, strings.ToLower(f.Name)) // key
+	hv = pbAppendString(hv, 2, f.Value)                 // value
+
+	b := pbAppendBytes(nil, 1, hv) // header
+	return pbAppendVarint(b, 3, overwriteIfExistsOrAdd)
+}
+
+// has reports whether v sets header name.
+func (v *verdict) has(name string) bool {
+	for _, f := range v.Headers {
+		if strings.EqualFold(f.Name, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// serveCheck serves ext_authz Check calls
+// and answers any other gRPC method with UNIMPLEMENTED.
+func (app *_App) serveCheck(w http.ResponseWriter, r *http.Request) {
+	c := core{Request: r, ResponseWriter: w, Epoch: time.Now(), Role: RoleGRPC}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+
+	if checkMethod != r.URL.Path {
+		grpcStatus(w, grpcUnimplemented, "unknown method "+r.URL.Path)
+		return
+	}
+
+	s := app.current()
+	buf := bytesPool.Get().([]byte)
+	defer func() { bytesPool.Put(buf[:0]) }()
+
+	msg, code, desc := readGRPCMessage(r.Body, buf, checkRequestSize(s.Limits))
+	if grpcOK != code {
+		grpcStatus(w, code, desc)
+		return
+	}
+
+	defer app.metrics.track(RoleGRPC)()
+	req, err := parseCheckRequest(msg)
+	if nil != err {
+		grpcStatus(w, grpcInvalidArgument, err.Error())
+		return
+	}
+
+	c.Target = req.Target
+	c.Target.Client = clientName(r)
+	v := authorize(s.Keys, &s.Auth, c.Epoch, req.Authorization, &c.Target)
+	c.set(&v)
+	app.LogRequest(&c)
+	app.metrics.observe(&c)
+
+	b := bytesPool.Get().([]byte)
+	defer func() { bytesPool.Put(b[:0]) }()
+
+	b = append(b[:0], 0, 0, 0, 0, 0) // uncompressed, length
+	b = appendCheckResponse(b, &s.Auth, &v)
+	binary.BigEndian.PutUint32(b[1:5], uint32(len(b)-5))
+
+	w.Write(b)
+	grpcStatus(w, grpcOK, "")
+}
+
+// readGRPCMessage reads the single, uncompressed message of a unary call
+// of up to max bytes into buf.
+// buf only grows as the message arrives, not by its declared length.
+func readGRPCMessage(r io.Reader, buf []byte, max int) (msg []byte, code int, desc string) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); nil != err {
+		return nil, grpcInvalidArgument, "missing request message"
+	}
+
+	if 0 != prefix[0] {
+		return nil, grpcUnimplemented, "compressed messages are not supported"
+	}
+
+	n := binary.BigEndian.Uint32(prefix[1:])
+	if int64(n) > int64(max) {
+		return nil, grpcResourceExhausted, "request message too large"
+	}
+
+	bb := bytes.NewBuffer(buf[:0])
+	if _, err := bb.ReadFrom(io.LimitReader(r, int64(n))); nil != err || bb.Len() != int(n) {
+		return nil, grpcInvalidArgument, "truncated request message"
+	}
+
+	return bb.Bytes(), grpcOK, ""
+}
+
+// grpcStatus sets the status trailers of a gRPC response.
+func grpcStatus(w http.ResponseWriter, code int, desc string) {
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if 0 != len(desc) {
+		w.Header().Set("Grpc-Message", percentEncode(desc))
+	}
+}
+
+// percentEncode encodes s as a Grpc-Message or other header value:
+// bytes outside of printable ASCII and '%' are percent-encoded.
+func percentEncode(s string) string {
+	const hex = "0123456789ABCDEF"
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < ' ' || c > '~' || '%' == c {
+			sb.WriteByte('%')
+			sb.WriteByte(hex[c>>4])
+			sb.WriteByte(hex[c&15])
+		} else {
+			sb.WriteByte(c)
+		}
+	}
+
+	return sb.String()
+}
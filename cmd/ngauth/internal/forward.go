@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// target is the request a reverse proxy asks ngauth to authorize,
+// as forwarded in the headers of the configured proxy (see proxyHeaders).
+// Absent headers fall back to the request to ngauth itself.
+type target struct {
+	Method string
+	Host   string // without port and trailing dot, see hostname
+	URI    string
+	Path   string // decoded and cleaned, see setURI
+
+	// PathErr is set if Path could not be determined safely,
+	// which denies targets that rules with a PathPrefix apply to.
+	PathErr error
+
+	// Client is the common name of the verified TLS client certificate
+	// of the proxy, if any.
+	Client string
+}
+
+// proxyHeaders are the request headers
+// that carry the target for each Auth.Proxy.
+// Only those of the configured proxy are read:
+// proxies pass on the headers clients send,
+// so the others could be set by anyone.
+var proxyHeaders = map[string]struct{ Method, Host, URI string }{
+	ProxyNginx:   {"X-Original-Method", "X-Original-Host", "X-Original-URI"},
+	ProxyTraefik: {"X-Forwarded-Method", "X-Forwarded-Host", "X-Forwarded-Uri"},
+}
+
+// forwardedTarget returns the target of r
+// as forwarded by proxy (see Auth.Proxy).
+func forwardedTarget(r *http.Request, proxy string) (t target) {
+	h, names := r.Header, proxyHeaders[proxy]
+	t.Method = firstOf(h.Get(names.Method), r.Method)
+	t.Host = firstOf(h.Get(names.Host), r.Host)
+	t.setURI(firstOf(h.Get(names.URI), r.RequestURI))
+	t.Client = clientName(r)
+
+	// the host header may list every proxy hop
+	if i := strings.IndexByte(t.Host, ','); i >= 0 {
+		t.Host = strings.TrimSpace(t.Host[:i])
+	}
+
+	t.Host = hostname(t.Host)
+	return
+}
+
+// setURI sets the URI of t and the path it contains,
+// decoded and cleaned of dot segments and duplicate slashes
+// (keeping a trailing slash),
+// so "/public/../admin" and "/public/%2e%2e/admin" match "/admin".
+// Paths with encoded slashes or backslashes,
+// which upstreams may or may not decode, and malformed URIs
+// set PathErr.
+func (t *target) setURI(uri string) {
+	t.URI, t.Path, t.PathErr = uri, uri, nil
+
+	u, err := url.ParseRequestURI(uri)
+	if nil != err {
+		t.PathErr = errBadTarget
+		return
+	}
+
+	if raw := strings.ToLower(u.EscapedPath()); strings.Contains(raw, "%2f") ||
+		strings.Contains(raw, "%5c") {
+		t.PathErr = errBadTarget
+	}
+
+	t.Path = path.Clean("/" + u.Path)
+	if "/" != t.Path && strings.HasSuffix(u.Path, "/") {
+		t.Path += "/"
+	}
+}
+
+// hostname returns host without port and trailing dot,
+// so "example.com:443" and "example.com." match "example.com".
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); nil == err {
+		host = h
+	} else if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+
+	return strings.TrimSuffix(host, ".")
+}
+
+// firstOf returns the first non-empty of ss.
+func firstOf(ss ...string) string {
+	for _, s := range ss {
+		if 0 != len(s) {
+			return s
+		}
+	}
+
+	return ""
+}
+
+// Rule requires requests to matching targets
+// to be authorized by tokens with all of Scopes.
+// Empty Host and PathPrefix match any target.
+// Host matches regardless of case, port and trailing dot,
+// PathPrefix matches the cleaned path (see target.setURI).
+// If Clients is set, the rule only applies to targets
+// forwarded by TLS clients with one of these certificate common names.
+type Rule struct {
+	Host       string   `yaml:"host"`
+	PathPrefix string   `yaml:"pathPrefix"`
+	Methods    []string `yaml:"methods"`
+	Clients    []string `yaml:"clients"`
+	Scopes     []string `yaml:"scopes"`
+}
+
+// matches reports whether r applies to t.
+// It returns t.PathErr if that leaves it undecided.
+func (r *Rule) matches(t *target) (bool, error) {
+	if 0 != len(r.Host) && !strings.EqualFold(hostname(r.Host), t.Host) {
+		return false, nil
+	}
+
+	if 0 != len(r.Clients) && !contains(r.Clients, t.Client) {
+		return false, nil
+	}
+
+	if 0 != len(r.Methods) && !containsFold(r.Methods, t.Method) {
+		return false, nil
+	}
+
+	if 0 == len(r.PathPrefix) {
+		return true, nil
+	}
+
+	if nil != t.PathErr {
+		return false, t.PathErr
+	}
+
+	return strings.HasPrefix(t.Path, r.PathPrefix), nil
+}
+
+// rule returns the first of rules that applies to t, if any,
+// or the error that kept it from being found.
+func rule(rules []Rule, t *target) (*Rule, bool, error) {
+	for i := range rules {
+		if ok, err := rules[i].matches(t); nil != err || ok {
+			return &rules[i], ok, err
+		}
+	}
+
+	return nil, false, nil
+}
+
+// containsFold reports whether ss contains s, regardless of case.
+func containsFold(ss []string, s string) bool {
+	for _, v := range ss {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+
+	return false
+}
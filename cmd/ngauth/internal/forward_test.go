@@ -0,0 +1,104 @@
+package internal
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRule(t *testing.T) {
+	t.Parallel()
+
+	rules := []Rule{
+		{Host: "api.example.com", Scopes: []string{"api"}},
+		{PathPrefix: "/admin", Scopes: []string{"admin"}},
+		{PathPrefix: "/public/"},
+	}
+
+	for i, c := range [...]struct {
+		host, uri string
+		rule      int // index of the matching rule, or -1
+		err       error
+	}{
+		{"example.com", "/admin/users", 1, nil},
+		{"example.com", "/public/../admin", 1, nil},
+		{"example.com", "/public/%2e%2e/admin", 1, nil},
+		{"example.com", "/public/%2E%2E/admin", 1, nil},
+		{"example.com", "//admin", 1, nil},
+		{"example.com", "/./admin?x=/public/", 1, nil},
+		{"example.com", "/public/a/../", 2, nil}, // trailing slash kept
+		{"example.com", "/public/a/..", -1, nil},
+		{"example.com", "/public/x", 2, nil},
+		{"example.com", "/public/x%2f..%2f..%2fadmin", -1, errBadTarget},
+		{"example.com", "/public/x%5C..%5Cadmin", -1, errBadTarget},
+		{"example.com", "/public/%zz", -1, errBadTarget},
+		{"example.com", "/other", -1, nil},
+		{"api.example.com", "/x", 0, nil},
+		{"API.example.com", "/x", 0, nil},
+		{"api.example.com:443", "/x", 0, nil},
+		{"api.example.com.", "/x", 0, nil},
+		{"api.example.com.:8443", "/x%2f", 0, nil}, // path does not matter
+		{"[::1]:443", "/x", -1, nil},
+	} {
+		r := httptest.NewRequest("GET", "/auth", nil)
+		r.Header.Set("X-Forwarded-Host", c.host)
+		r.Header.Set("X-Forwarded-Uri", c.uri)
+
+		tg := forwardedTarget(r, ProxyTraefik)
+		ru, ok, err := rule(rules, &tg)
+		if err != c.err {
+			t.Errorf("i=%d: expected error %v, actual %v", i, c.err, err)
+			continue
+		}
+
+		act := -1
+		for j := range rules {
+			if ok && ru == &rules[j] {
+				act = j
+			}
+		}
+
+		if act != c.rule {
+			t.Errorf("i=%d: expected rule %d, actual %d (path %q)", i, c.rule, act, tg.Path)
+		}
+	}
+
+	if h := hostname("[::1]:443"); "::1" != h {
+		t.Errorf("expected ::1, actual %q", h)
+	}
+}
+
+func TestForwardedTarget(t *testing.T) {
+	t.Parallel()
+
+	// clients can send the headers of either proxy,
+	// only those of the configured one must be read
+	headers := map[string]string{
+		"X-Original-Method":  "DELETE",
+		"X-Original-Host":    "admin.example.com",
+		"X-Original-URI":     "/admin/x",
+		"X-Forwarded-Method": "GET",
+		"X-Forwarded-Host":   "public.example.com, proxy",
+		"X-Forwarded-Uri":    "/public",
+	}
+
+	for i, c := range [...]struct {
+		proxy             string
+		method, host, uri string
+	}{
+		{ProxyNginx, "DELETE", "admin.example.com", "/admin/x"},
+		{ProxyTraefik, "GET", "public.example.com", "/public"},
+		{"", "POST", "ngauth", "/auth"},
+	} {
+		r := httptest.NewRequest("POST", "/auth", nil)
+		r.Host = "ngauth"
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+
+		tg := forwardedTarget(r, c.proxy)
+		if tg.Method != c.method || tg.Host != c.host || tg.URI != c.uri {
+			t.Errorf("i=%d: expected %s %s%s, actual %s %s%s", i,
+				c.method, c.host, c.uri, tg.Method, tg.Host, tg.URI)
+		}
+	}
+}
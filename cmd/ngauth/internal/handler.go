@@ -1,9 +1,9 @@
 package internal
 
 import (
-	"encoding/base64"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,12 +16,25 @@ var bytesPool = sync.Pool{
 	},
 }
 
+// quoter escapes quoted-string values (RFC 7230, sec. 3.2.6).
+var quoter = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
 type core struct {
 	*http.Request
 	http.ResponseWriter
 
 	Epoch  time.Time
 	Status int
+
+	Role    string // of the listener, see Bind
+	Target  target
+	Subject string
+	Err     error  // reason for denying the request, if any
+	Body    []byte // of the response, see Auth.ClaimsBody
+
+	Decrypted bool
+	KeyID     string    // of the token, if decrypted, see fpast2l.KeyID
+	IssuedAt  time.Time // iat claim of the token, if any
 }
 
 func (c *core) Write(b []byte) (n int, err error) {
@@ -30,36 +43,164 @@ func (c *core) Write(b []byte) (n int, err error) {
 	return w.Write(b)
 }
 
-func (c *core) Decrypt(eng *fpast2l.Engine) {
+// Authorize authorizes the forwarded target of the request
+// (see authorize)
+// and sets the status, identity and claim headers, claims body
+// and WWW-Authenticate challenge.
+func (c *core) Authorize(keys keyring, a *Auth) {
+	c.Target = forwardedTarget(c.Request, a.Proxy)
+	v := authorize(keys, a, c.Epoch, c.Request.Header.Get("Authorization"), &c.Target)
+
+	h := c.ResponseWriter.Header()
+	for _, f := range v.Headers {
+		h.Set(f.Name, f.Value)
+	}
+
+	if 0 != len(v.Challenge) {
+		h.Set("WWW-Authenticate", v.Challenge)
+	}
+
+	if nil != v.Body {
+		h.Set("Content-Type", "application/json")
+	}
+
+	c.set(&v)
+}
+
+// set records the outcome v in c.
+func (c *core) set(v *verdict) {
+	c.Status, c.Subject, c.Err, c.Body = v.Status, v.Subject, v.Err, v.Body
+	c.Decrypted, c.KeyID, c.IssuedAt = v.Decrypted, v.KeyID, v.IssuedAt
+}
+
+// header is a response header field.
+type header struct{ Name, Value string }
+
+// verdict is the outcome of authorizing a target.
+type verdict struct {
+	Status    int      // 200, 401 or 403
+	Challenge string   // WWW-Authenticate value, unless Status is 200
+	Headers   []header // identity and claim headers, if Status is 200
+	Body      []byte   // claims, if Status is 200 and Auth.ClaimsBody is set
+	Subject   string
+	Err       error // reason for denying the target, if any
+
+	Decrypted bool
+	KeyID     string    // if Decrypted
+	IssuedAt  time.Time // if Decrypted and the token has an iat claim
+}
+
+// authorize decrypts the bearer token in auth,
+// the value of an Authorization header,
+// validates its claims at now
+// and checks them against the rule for t.
+// It is shared by the forward-auth handler and ext_authz Check.
+func authorize(keys keyring, a *Auth, now time.Time, auth string, t *target) (v verdict) {
 	const Bearer = "Bearer "
-	auth := c.Request.Header.Get("Authorization")
-	if len(Bearer) >= len(auth) {
-		c.Status = http.StatusUnauthorized
+	if len(auth) <= len(Bearer) || !strings.EqualFold(auth[:len(Bearer)], Bearer) {
+		v.Err = errMissingToken
+		v.challenge(a, http.StatusUnauthorized, "", "", "")
 		return
 	}
 
-	auth = auth[len(Bearer):]
 	buf := bytesPool.Get().([]byte)
 	defer func() { bytesPool.Put(buf[:0]) }()
 
 	err := error(nil)
-	if buf, err = eng.Decrypt(buf[:0], auth); nil != err {
-		c.Status = http.StatusUnauthorized
+	if buf, err = keys.Decrypt(buf[:0], auth[len(Bearer):]); nil != err {
+		v.Err = err
+		v.challenge(a, http.StatusUnauthorized,
+			"invalid_token", "token could not be decrypted", "")
 		return
 	}
 
-	c.ResponseWriter.Header().Add(
-		"Authorization", Bearer+base64.RawURLEncoding.EncodeToString(buf),
-	)
+	v.Decrypted = true
+	v.KeyID, _ = fpast2l.KeyID(auth[len(Bearer):])
+	cl, err := parseClaims(buf)
+	if nil == err {
+		v.IssuedAt = cl.IssuedAt
+		err = cl.validate(now, a)
+	}
 
-	c.Status = http.StatusOK
+	if nil != err {
+		v.Err = err
+		v.challenge(a, http.StatusUnauthorized, "invalid_token", err.Error(), "")
+		return
+	}
+
+	v.Subject = cl.Subject
+	r, ok, err := rule(a.Rules, t)
+	switch {
+	case nil != err:
+		v.Err = err
+		v.challenge(a, http.StatusForbidden, "invalid_request", err.Error(), "")
+		return
+	case !ok && a.DefaultDeny:
+		v.Err = errNoRule
+		v.challenge(a, http.StatusForbidden, "", "", "")
+		return
+	case ok && !cl.Scopes.has(r.Scopes):
+		v.Err = errInsufficientScope
+		v.challenge(a, http.StatusForbidden,
+			"insufficient_scope", "", strings.Join(r.Scopes, " "))
+		return
+	}
+
+	for _, f := range [...]header{
+		{a.Headers.Subject, cl.Subject},
+		{a.Headers.Scopes, cl.Scopes.String()},
+		{a.Headers.Issuer, cl.Issuer},
+		{a.Headers.TokenID, cl.TokenID},
+	} {
+		if "-" != f.Name && 0 != len(f.Value) {
+			v.Headers = append(v.Headers, header{f.Name, percentEncode(f.Value)})
+		}
+	}
+
+	v.setClaims(a, buf)
+
+	v.Status = http.StatusOK
+	return
+}
+
+// challenge sets status
+// and a Bearer WWW-Authenticate challenge (RFC 6750, sec. 3)
+// with the given error code, description and required scope, if any.
+func (v *verdict) challenge(a *Auth, status int, code, desc, scope string) {
+	var sb strings.Builder
+	sb.WriteString(`Bearer realm="`)
+	quoter.WriteString(&sb, a.Realm)
+	sb.WriteByte('"')
+
+	for _, p := range [...]struct{ key, value string }{
+		{"error", code},
+		{"error_description", desc},
+		{"scope", scope},
+	} {
+		if 0 != len(p.value) {
+			sb.WriteString(", " + p.key + `="`)
+			quoter.WriteString(&sb, p.value)
+			sb.WriteByte('"')
+		}
+	}
+
+	v.Challenge = sb.String()
+	v.Status = status
 }
 
 func (app *_App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	const bearer = "Bearer "
-	c := core{Request: r, ResponseWriter: w, Epoch: time.Now()}
-	c.Decrypt(&app.Engine)
+	s := app.current()
+	if "/token" == r.URL.Path && 0 != len(s.Issue.Clients) {
+		app.serveToken(w, r, s)
+		return
+	}
+
+	c := core{Request: r, ResponseWriter: w, Epoch: time.Now(), Role: RoleAuth}
+	defer app.metrics.track(RoleAuth)()
+
+	c.Authorize(s.Keys, &s.Auth)
 
 	app.LogRequest(&c)
-	c.Write(nil)
+	app.metrics.observe(&c)
+	c.Write(c.Body)
 }
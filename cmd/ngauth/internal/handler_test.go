@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/zrhmn/fpast2l"
+)
+
+func TestAppForwardAuth(t *testing.T) {
+	t.Parallel()
+
+	var cfg Config
+	if _, err := rand.Read(cfg.PASETO.Key[:]); nil != err {
+		t.Fatal(err)
+	}
+
+	cfg.Auth.Audience = "api"
+	cfg.Auth.Headers.Issuer = "-"
+	cfg.Auth.Rules = []Rule{
+		{PathPrefix: "/admin", Scopes: []string{"admin"}},
+		{Host: "write.example.com", Methods: []string{"POST"}, Scopes: []string{"write"}},
+	}
+
+	app, err := NewApp(cfg)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	eng := fpast2l.New(cfg.PASETO.Key[:])
+	token := func(exp time.Time, aud, scopes string) string {
+		return eng.Encrypt([]byte(fmt.Sprintf(
+			`{"sub":"alice","iss":"me","aud":%q,"exp":%q,"scopes":%s}`,
+			aud, exp.Format(time.RFC3339), scopes)))
+	}
+
+	later := time.Now().Add(time.Hour)
+	ok := token(later, "api", `"read admin"`)
+	reader := token(later, "api", `["read"]`)
+
+	for i, c := range [...]struct {
+		auth    string
+		headers map[string]string
+		status  int
+		www     string
+		subject string
+	}{
+		{"", nil, 401, `Bearer realm="ngauth"`, ""},
+		{"Bearer v2.local.AAAA", nil, 401,
+			`Bearer realm="ngauth", error="invalid_token", error_description="token could not be decrypted"`, ""},
+		{"Bearer " + eng.Encrypt([]byte("not json")), nil, 401,
+			`Bearer realm="ngauth", error="invalid_token", error_description="malformed claims"`, ""},
+		{"Bearer " + token(time.Now().Add(-time.Hour), "api", `""`), nil, 401,
+			`Bearer realm="ngauth", error="invalid_token", error_description="token expired"`, ""},
+		{"Bearer " + eng.Encrypt([]byte(`{"sub":"alice","aud":"api"}`)), nil, 401,
+			`Bearer realm="ngauth", error="invalid_token", error_description="token has no expiration"`, ""},
+		{"Bearer " + token(later, "web", `""`), nil, 401,
+			`Bearer realm="ngauth", error="invalid_token", error_description="token not issued for this audience"`, ""},
+		{"Bearer " + ok, nil, 200, "", "alice"},
+		{"bearer " + reader, map[string]string{"X-Original-URI": "/admin/users?x=1"}, 403,
+			`Bearer realm="ngauth", error="insufficient_scope", scope="admin"`, ""},
+		{"Bearer " + ok, map[string]string{"X-Original-URI": "/admin/users"}, 200, "", "alice"},
+		{"Bearer " + reader, map[string]string{
+			"X-Original-Method": "POST",
+			"X-Original-Host":   "write.example.com, proxy",
+		}, 403, `Bearer realm="ngauth", error="insufficient_scope", scope="write"`, ""},
+		{"Bearer " + reader, map[string]string{
+			"X-Original-Method": "GET",
+			"X-Original-Host":   "write.example.com",
+		}, 200, "", "alice"},
+		{"Bearer " + reader, map[string]string{
+			"X-Original-Method": "POST",
+			"X-Original-Host":   "Write.example.com.:443",
+		}, 403, `Bearer realm="ngauth", error="insufficient_scope", scope="write"`, ""},
+		// Traefik headers sent by the client are not read
+		{"Bearer " + reader, map[string]string{
+			"X-Original-URI":   "/admin/users",
+			"X-Forwarded-Uri":  "/public",
+			"X-Forwarded-Host": "read.example.com",
+		}, 403, `Bearer realm="ngauth", error="insufficient_scope", scope="admin"`, ""},
+		{"Bearer " + reader, map[string]string{
+			"X-Original-Method":  "POST",
+			"X-Original-Host":    "write.example.com",
+			"X-Forwarded-Method": "GET",
+		}, 403, `Bearer realm="ngauth", error="insufficient_scope", scope="write"`, ""},
+		{"Bearer " + reader, map[string]string{"X-Original-URI": "/public/%2e%2e/admin"}, 403,
+			`Bearer realm="ngauth", error="insufficient_scope", scope="admin"`, ""},
+		{"Bearer " + ok, map[string]string{"X-Original-URI": "/public%2f..%2fadmin"}, 403,
+			`Bearer realm="ngauth", error="invalid_request", error_description="malformed request target"`, ""},
+	} {
+		r := httptest.NewRequest("GET", "/auth", nil)
+		if 0 != len(c.auth) {
+			r.Header.Set("Authorization", c.auth)
+		}
+
+		for k, v := range c.headers {
+			r.Header.Set(k, v)
+		}
+
+		w := httptest.NewRecorder()
+		app.(http.Handler).ServeHTTP(w, r)
+
+		if w.Code != c.status {
+			t.Errorf("i=%d: expected status %d, actual %d", i, c.status, w.Code)
+		}
+
+		if act := w.Header().Get("WWW-Authenticate"); act != c.www {
+			t.Errorf("i=%d: expected WWW-Authenticate %q, actual %q", i, c.www, act)
+		}
+
+		if act := w.Header().Get("X-Auth-Subject"); act != c.subject {
+			t.Errorf("i=%d: expected X-Auth-Subject %q, actual %q", i, c.subject, act)
+		}
+
+		if act := w.Header().Get("X-Auth-Issuer"); 0 != len(act) {
+			t.Errorf("i=%d: expected no X-Auth-Issuer, actual %q", i, act)
+		}
+	}
+
+	// requests matching no rule are denied with DefaultDeny
+	cfg.Auth.DefaultDeny = true
+	if app, err = NewApp(cfg); nil != err {
+		t.Fatal(err)
+	}
+
+	for i, c := range [...]struct {
+		uri    string
+		status int
+	}{
+		{"/admin/users", 200},
+		{"/other", 403},
+	} {
+		r := httptest.NewRequest("GET", "/auth", nil)
+		r.Header.Set("Authorization", "Bearer "+ok)
+		r.Header.Set("X-Original-URI", c.uri)
+
+		w := httptest.NewRecorder()
+		app.(http.Handler).ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Errorf("i=%d: expected status %d, actual %d", i, c.status, w.Code)
+		}
+	}
+	// tokens without exp are accepted with AllowNoExp
+	cfg.Auth.DefaultDeny, cfg.Auth.AllowNoExp = false, true
+	if app, err = NewApp(cfg); nil != err {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/auth", nil)
+	r.Header.Set("Authorization", "Bearer "+eng.Encrypt([]byte(`{"sub":"alice","aud":"api"}`)))
+
+	w := httptest.NewRecorder()
+	app.(http.Handler).ServeHTTP(w, r)
+	if 200 != w.Code {
+		t.Errorf("expected status 200 without exp, actual %d", w.Code)
+	}
+
+	// with Traefik, nginx headers sent by the client are not read
+	cfg.Auth.Proxy = ProxyTraefik
+	if app, err = NewApp(cfg); nil != err {
+		t.Fatal(err)
+	}
+
+	r = httptest.NewRequest("GET", "/auth", nil)
+	r.Header.Set("Authorization", "Bearer "+reader)
+	r.Header.Set("X-Forwarded-Uri", "/admin/x")
+	r.Header.Set("X-Original-URI", "/public")
+
+	w = httptest.NewRecorder()
+	app.(http.Handler).ServeHTTP(w, r)
+	if 403 != w.Code {
+		t.Errorf("expected status 403, actual %d", w.Code)
+	}
+}
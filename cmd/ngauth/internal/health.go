@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/zrhmn/fpast2l"
+)
+
+var (
+	errDraining = errors.New("shutting down")
+	errSealed   = errors.New("no key until unsealed")
+)
+
+// check is a readiness check (see serveReady).
+type check struct {
+	Name  string
+	Check func() error // nil if ready
+}
+
+// checks returns the readiness checks of app:
+// that it is not stopping, that all listeners are serving,
+// that a key is available and that it is not waiting for key shares.
+func (app *_App) checks() []check {
+	return []check{
+		{"shutdown", func() error {
+			if 0 != atomic.LoadInt32(&app.draining) {
+				return errDraining
+			}
+
+			return nil
+		}},
+		{"listeners", func() error {
+			if n := atomic.LoadInt32(&app.serving); int(n) != len(app.Config.Binds) {
+				return fmt.Errorf("%d of %d serving", n, len(app.Config.Binds))
+			}
+
+			return nil
+		}},
+		{"keys", func() error {
+			s, _ := app.state.Load().(*state)
+			if nil == s {
+				return errSealed
+			}
+
+			w := s.watcher
+			if nil == w {
+				return nil
+			}
+
+			if _, ok := w.Keyring().Active(time.Now()); !ok {
+				return fpast2l.ErrNoActiveKey
+			}
+
+			return nil
+		}},
+		{"unseal", func() error {
+			if k := atomic.LoadInt32(&app.unsealing); 0 != k {
+				return fmt.Errorf("waiting for key shares, %d of %d accepted",
+					k-1, app.Config.PASETO.Unseal.Threshold)
+			}
+
+			return nil
+		}},
+	}
+}
+
+// serveHealth answers liveness probes:
+// the app is alive as long as it answers.
+func (app *_App) serveHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// serveReady answers readiness probes with 200
+// if all checks pass, or 503 otherwise.
+// The result of every check is listed
+// if any fails or the verbose query parameter is given.
+func (app *_App) serveReady(w http.ResponseWriter, r *http.Request) {
+	var (
+		sb     strings.Builder
+		failed bool
+	)
+
+	for _, c := range app.checks() {
+		if err := c.Check(); nil != err {
+			failed = true
+			fmt.Fprintf(&sb, "[-]%s failed: %v\n", c.Name, err)
+		} else {
+			fmt.Fprintf(&sb, "[+]%s ok\n", c.Name)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if failed {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(sb.String() + "not ready\n"))
+		return
+	}
+
+	if _, ok := r.URL.Query()["verbose"]; ok {
+		w.Write([]byte(sb.String()))
+	}
+
+	w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"crypto/rand"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zrhmn/fpast2l"
+)
+
+func TestAppHealth(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	var key [n]byte
+	if _, err := rand.Read(key[:]); nil != err {
+		t.Fatal(err)
+	}
+
+	// the only key is not valid yet, so nothing can be decrypted
+	var cfg Config
+	cfg.PASETO.Keyring = filepath.Join(dir, "keyring.json")
+	cfg.PASETO.KeyringInterval = -1
+	cfg.Binds = []Bind{{Address: "127.0.0.1:0"}, {Address: "127.0.0.1:0", Role: RoleAdmin}}
+	cfg.DrainDelay = 500 * time.Millisecond
+
+	write := func(notBefore time.Time) {
+		if err := ioutil.WriteFile(cfg.PASETO.Keyring, []byte(`{"keys": [{
+			"id": "k0", "status": "active", "key": "`+fpast2l.FormatKey(key[:])+`",
+			"notBefore": "`+notBefore.Format(time.RFC3339)+`"}]}`), 0600); nil != err {
+			t.Fatal(err)
+		}
+	}
+
+	write(time.Now().Add(time.Hour))
+
+	a, err := NewApp(cfg)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	app := a.(*_App)
+	if err := app.checks()[1].Check(); nil == err {
+		t.Errorf("expected listeners not to be ready before Start")
+	}
+
+	stop := startApp(t, app)
+	admin := "http://" + app.addr(RoleAdmin).String()
+	client := &http.Client{Transport: new(http.Transport)}
+	get := func(path string) (int, string) {
+		defer client.CloseIdleConnections() // see startApp
+		resp, err := client.Get(admin + path)
+		if nil != err {
+			t.Fatal(err)
+		}
+
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	// wait for the listeners
+	for i := 0; i < 100 && nil != app.checks()[1].Check(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if status, _ := get("/healthz"); 200 != status {
+		t.Errorf("expected /healthz 200, actual %d", status)
+	}
+
+	status, body := get("/readyz")
+	if 503 != status || !strings.Contains(body, "[-]keys failed: ") ||
+		!strings.Contains(body, "[+]listeners ok") {
+		t.Errorf("expected /readyz 503 for keys, actual %d %q", status, body)
+	}
+
+	write(time.Now().Add(-time.Hour))
+	if err := app.current().watcher.Reload(); nil != err {
+		t.Fatal(err)
+	}
+
+	if status, body := get("/readyz"); 200 != status || "ok\n" != body {
+		t.Errorf("expected /readyz 200, actual %d %q", status, body)
+	}
+
+	if status, body := get("/readyz?verbose"); 200 != status || !strings.HasPrefix(body, "[+]shutdown ok\n") {
+		t.Errorf("expected verbose /readyz, actual %d %q", status, body)
+	}
+
+	// not ready, but still answering, while draining
+	stopped := make(chan struct{})
+	go func() {
+		stop()
+		close(stopped)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	if status, body := get("/readyz"); 503 != status || !strings.Contains(body, "[-]shutdown failed") {
+		t.Errorf("expected /readyz 503 while draining, actual %d %q", status, body)
+	}
+
+	<-stopped
+	if _, err := client.Get(admin + "/healthz"); nil == err {
+		t.Errorf("expected admin listener to be closed")
+	}
+}
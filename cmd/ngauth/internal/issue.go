@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	errBadClient          = errors.New("unknown client or bad credentials")
+	errAudienceNotAllowed = errors.New("audience not allowed")
+	errClaimsTooLarge     = errors.New("claims too large")
+)
+
+// tokenResponse is the response to POST /token (see Issue),
+// after RFC 6749, sec. 5.1.
+type tokenResponse struct {
+	AccessToken string    `json:"access_token"`
+	TokenType   string    `json:"token_type"`
+	ExpiresIn   int64     `json:"expires_in"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// errorResponse is the response to failed token requests,
+// after RFC 6749, sec. 5.2.
+type errorResponse struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// serveToken issues a token to an authenticated client (see Issue).
+func (app *_App) serveToken(w http.ResponseWriter, r *http.Request, s *state) {
+	now := time.Now()
+	is := &s.Issue
+
+	if http.MethodPost != r.Method {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed,
+			errorResponse{"invalid_request", "method not allowed"})
+		return
+	}
+
+	cl, ok := is.client(r)
+	if !ok {
+		app.LogEvent("ISSUE").Str("remoteAddr", r.RemoteAddr).
+			AnErr("reason", errBadClient).Send()
+
+		w.Header().Set("WWW-Authenticate",
+			`Basic realm="`+quoter.Replace(s.Auth.Realm)+`"`)
+		writeJSON(w, http.StatusUnauthorized,
+			errorResponse{"invalid_client", errBadClient.Error()})
+		return
+	}
+
+	max := int64(s.Limits.MaxPayloadSize)
+	if max <= 0 {
+		max = 1 << 20
+	}
+
+	var m map[string]interface{}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, max))
+	dec.UseNumber()
+
+	err := dec.Decode(&m)
+	if nil == err && nil == m {
+		err = errMalformedClaims
+	}
+
+	exp := time.Time{}
+	if nil == err {
+		exp, err = is.apply(m, now)
+	}
+
+	b := []byte(nil)
+	if nil == err {
+		b, err = json.Marshal(m)
+	}
+
+	if nil == err && int64(len(b)) > max {
+		err = errClaimsTooLarge
+	}
+
+	if nil == err {
+		_, err = parseClaims(b) // tokens must be usable with ngauth
+	}
+
+	if nil != err {
+		status := http.StatusBadRequest
+		if errAudienceNotAllowed == err {
+			status = http.StatusForbidden
+		}
+
+		app.LogEvent("ISSUE").Str("client", cl.ID).AnErr("reason", err).Send()
+		writeJSON(w, status, errorResponse{"invalid_request", err.Error()})
+		return
+	}
+
+	sub, _ := m["sub"].(string)
+	token, err := s.mint(b)
+	if nil != err {
+		app.Logger.Error().Str("event", "ISSUE").Str("client", cl.ID).Err(err).Send()
+		writeJSON(w, http.StatusServiceUnavailable,
+			errorResponse{"temporarily_unavailable", err.Error()})
+		return
+	}
+
+	app.metrics.issued.With(cl.ID).Inc()
+	app.LogEvent("ISSUE").
+		Str("client", cl.ID).
+		Str("subject", sub).
+		Time("expiresAt", exp).
+		Send()
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, tokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(exp.Sub(now) / time.Second),
+		ExpiresAt:   exp,
+	})
+}
+
+// client returns the client that sent r, if it authenticated,
+// by TLS client certificate or by HTTP Basic auth.
+func (is *Issue) client(r *http.Request) (*Client, bool) {
+	if cn := clientName(r); 0 != len(cn) {
+		for i := range is.Clients {
+			if c := &is.Clients[i]; 0 != len(c.CommonName) && c.CommonName == cn {
+				return c, true
+			}
+		}
+	}
+
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		return nil, false
+	}
+
+	for i := range is.Clients {
+		c := &is.Clients[i]
+		if 0 != len(c.Secret) && c.ID == id &&
+			1 == subtle.ConstantTimeCompare([]byte(c.Secret), []byte(secret)) {
+			return c, true
+		}
+	}
+
+	return nil, false
+}
+
+// apply applies the policy of is to the requested claims m at now
+// and returns the expiration of the token.
+// The iat claim is set to now and a random jti is added if missing.
+func (is *Issue) apply(m map[string]interface{}, now time.Time) (exp time.Time, err error) {
+	if 0 != len(is.Issuer) {
+		m["iss"] = is.Issuer
+	}
+
+	if 0 != len(is.Audiences) {
+		aud, _ := m["aud"].(string)
+		if !contains(is.Audiences, aud) {
+			return exp, errAudienceNotAllowed
+		}
+	}
+
+	max := now.Add(is.MaxTTL).Truncate(time.Second)
+	exp = max
+	if v, ok := m["exp"]; ok {
+		s, _ := v.(string)
+		if exp, err = time.Parse(time.RFC3339, s); nil != err {
+			return exp, errMalformedClaims
+		}
+
+		if !exp.After(now) {
+			return exp, errExpired
+		}
+
+		if exp.After(max) {
+			exp = max
+		}
+	}
+
+	m["exp"] = exp.UTC().Format(time.RFC3339Nano)
+	m["iat"] = now.UTC().Format(time.RFC3339)
+
+	if _, ok := m["jti"]; !ok {
+		var id [16]byte
+		if _, err = rand.Read(id[:]); nil != err {
+			return exp, err
+		}
+
+		m["jti"] = hex.EncodeToString(id[:])
+	}
+
+	return exp.UTC(), nil
+}
+
+// contains reports whether ss contains s.
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeJSON writes v as the JSON body of a response with status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, _ := json.Marshal(v)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)+1))
+	w.WriteHeader(status)
+	w.Write(append(b, '\n'))
+}
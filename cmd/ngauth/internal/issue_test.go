@@ -0,0 +1,104 @@
+package internal
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppIssue(t *testing.T) {
+	t.Parallel()
+
+	var cfg Config
+	if _, err := rand.Read(cfg.PASETO.Key[:]); nil != err {
+		t.Fatal(err)
+	}
+
+	cfg.Auth.Audience = "api"
+	cfg.Auth.Issuer = "ngauth"
+	cfg.Issue.Clients = []Client{{ID: "svc", Secret: "s3cret"}, {ID: "mtls"}}
+	cfg.Issue.MaxTTL = 10 * time.Minute
+	cfg.Issue.Audiences = []string{"api", "web"}
+	cfg.Issue.Issuer = "ngauth"
+
+	app, err := NewApp(cfg)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	h := app.(http.Handler)
+	later := time.Now().Add(time.Minute).UTC().Format(time.RFC3339)
+	for i, c := range [...]struct {
+		method string
+		user   string
+		pass   string
+		body   string
+		status int
+		ttl    time.Duration // upper bound of expires_in, if 200
+	}{
+		{"GET", "svc", "s3cret", `{}`, 405, 0},
+		{"POST", "", "", `{"aud":"api"}`, 401, 0},
+		{"POST", "svc", "wrong", `{"aud":"api"}`, 401, 0},
+		{"POST", "mtls", "", `{"aud":"api"}`, 401, 0},
+		{"POST", "svc", "s3cret", `not json`, 400, 0},
+		{"POST", "svc", "s3cret", `["aud"]`, 400, 0},
+		{"POST", "svc", "s3cret", `{"aud":"db"}`, 403, 0},
+		{"POST", "svc", "s3cret", `{"aud":"api","exp":"2001-01-01T00:00:00Z"}`, 400, 0},
+		{"POST", "svc", "s3cret", `{"aud":"api","scopes":42}`, 400, 0},
+		{"POST", "svc", "s3cret", `{"aud":"api","x":"` + strings.Repeat("x", 8<<10) + `"}`, 400, 0},
+		{"POST", "svc", "s3cret", `{"sub":"alice","aud":"api","iss":"me"}`, 200, 10 * time.Minute},
+		{"POST", "svc", "s3cret", `{"sub":"alice","aud":"api","exp":"2999-01-01T00:00:00Z"}`, 200, 10 * time.Minute},
+		{"POST", "svc", "s3cret", `{"sub":"alice","aud":"api","exp":"` + later + `"}`, 200, time.Minute},
+	} {
+		r := httptest.NewRequest(c.method, "/token", strings.NewReader(c.body))
+		if 0 != len(c.user) {
+			r.SetBasicAuth(c.user, c.pass)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != c.status {
+			t.Errorf("i=%d: expected status %d, actual %d (%s)", i, c.status, w.Code, w.Body)
+			continue
+		}
+
+		if 401 == c.status && `Basic realm="ngauth"` != w.Header().Get("WWW-Authenticate") {
+			t.Errorf("i=%d: expected Basic challenge, actual %q", i, w.Header().Get("WWW-Authenticate"))
+		}
+
+		if 200 != c.status {
+			continue
+		}
+
+		var tr tokenResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &tr); nil != err {
+			t.Fatalf("i=%d: %v", i, err)
+		}
+
+		if exp := time.Duration(tr.ExpiresIn) * time.Second; exp <= 0 || exp > c.ttl {
+			t.Errorf("i=%d: expected 0 < expires_in <= %v, actual %v", i, c.ttl, exp)
+		}
+
+		// issued tokens must be accepted by forward-auth,
+		// which requires iss "ngauth" and aud "api"
+		r = httptest.NewRequest("GET", "/auth", nil)
+		r.Header.Set("Authorization", tr.TokenType+" "+tr.AccessToken)
+
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if 200 != w.Code || "alice" != w.Header().Get("X-Auth-Subject") {
+			t.Errorf("i=%d: issued token rejected: %d %s",
+				i, w.Code, w.Header().Get("WWW-Authenticate"))
+		}
+
+		if 0 == len(w.Header().Get("X-Auth-Token-Id")) {
+			t.Errorf("i=%d: expected a jti", i)
+		}
+	}
+}
@@ -0,0 +1,476 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/zrhmn/fpast2l"
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix prefixes the environment variables read by ParseConfig.
+const EnvPrefix = "NGAUTH_"
+
+// file is the format of configuration files,
+// a YAML document mirroring Config
+// with the key, log output and level as strings.
+type file struct {
+	Log struct {
+		Level  string `yaml:"level"`
+		Output string `yaml:"output"`
+	} `yaml:"log"`
+
+	Binds      []Bind        `yaml:"binds"`
+	DrainDelay time.Duration `yaml:"drainDelay"`
+
+	TLS TLS `yaml:"tls"`
+
+	PASETO struct {
+		Key     secret `yaml:"key"`
+		KeyFile string `yaml:"keyFile"`
+		Footer  string `yaml:"footer"`
+
+		Unseal struct {
+			Socket      string `yaml:"socket"`
+			Threshold   int    `yaml:"threshold"`
+			Fingerprint string `yaml:"fingerprint"`
+		} `yaml:"unseal"`
+
+		LockKey         bool          `yaml:"lockKey"`
+		Keyring         string        `yaml:"keyring"`
+		KeyringInterval time.Duration `yaml:"keyringInterval"`
+
+		Limits struct {
+			MaxTokenSize   int `yaml:"maxTokenSize"`
+			MaxFooterSize  int `yaml:"maxFooterSize"`
+			MaxPayloadSize int `yaml:"maxPayloadSize"`
+		} `yaml:"limits"`
+	} `yaml:"paseto"`
+
+	Auth  Auth  `yaml:"auth"`
+	Issue Issue `yaml:"issue"`
+}
+
+// setting is a file field
+// that can be overridden by an environment variable (EnvPrefix+Env)
+// and, unless Flag is empty, by a command line flag.
+type setting struct {
+	Flag, Env, Usage string
+	Set              func(f *file, s string) error
+	Bool             bool
+}
+
+// settings are overridable by environment and flags.
+// The key can only be set from the environment,
+// where it does not show up in process listings.
+var settings = [...]setting{
+	{"log-level", "LOG_LEVEL", "log `level` (debug, info, warn, error, disabled)",
+		str(func(f *file) *string { return &f.Log.Level }), false},
+	{"log-output", "LOG_OUTPUT", "log `output`: \"stdout\", \"stderr\", \"none\" or a file path",
+		str(func(f *file) *string { return &f.Log.Output }), false},
+	{"bind", "BINDS", "comma-separated `binds` replacing those of the file, " +
+		"each [auth|grpc|admin=][tcp|tcp4|tcp6|unix:]address",
+		func(f *file, s string) (err error) { f.Binds, err = parseBinds(s); return }, false},
+	{"tls-cert", "TLS_CERT_FILE", "`path` to the PEM certificate chain for TLS listeners",
+		str(func(f *file) *string { return &f.TLS.CertFile }), false},
+	{"tls-key", "TLS_KEY_FILE", "`path` to the PEM private key for TLS listeners",
+		str(func(f *file) *string { return &f.TLS.KeyFile }), false},
+	{"tls-client-ca", "TLS_CLIENT_CA_FILE", "`path` to the PEM CAs that verify client certificates",
+		str(func(f *file) *string { return &f.TLS.ClientCAFile }), false},
+	{"drain-delay", "DRAIN_DELAY", "`duration` to fail readiness probes before shutting down",
+		dur(func(f *file) *time.Duration { return &f.DrainDelay }), false},
+	{"", "KEY", "",
+		func(f *file, s string) error { zero(f.PASETO.Key); f.PASETO.Key = secret(s); return nil }, false},
+	{"key-file", "KEY_FILE", "`path` to a file containing the key (hex or k2.local.)",
+		str(func(f *file) *string { return &f.PASETO.KeyFile }), false},
+	{"footer", "FOOTER", "`footer` of tokens",
+		str(func(f *file) *string { return &f.PASETO.Footer }), false},
+	{"keyring", "KEYRING", "`path` to the keyring file",
+		str(func(f *file) *string { return &f.PASETO.Keyring }), false},
+	{"keyring-interval", "KEYRING_INTERVAL", "`interval` at which to check the keyring file for changes",
+		dur(func(f *file) *time.Duration { return &f.PASETO.KeyringInterval }), false},
+	{"lock-key", "LOCK_KEY", "keep the key in locked memory excluded from core dumps",
+		boolean(func(f *file) *bool { return &f.PASETO.LockKey }), true},
+	{"unseal", "UNSEAL_SOCKET", "wait for key shares on the Unix socket at `path` before starting",
+		str(func(f *file) *string { return &f.PASETO.Unseal.Socket }), false},
+	{"unseal-threshold", "UNSEAL_THRESHOLD", "`number` of key shares required to unseal",
+		integer(func(f *file) *int { return &f.PASETO.Unseal.Threshold }), false},
+	{"unseal-fingerprint", "UNSEAL_FINGERPRINT", "`fingerprint` of the key that the shares must combine into",
+		str(func(f *file) *string { return &f.PASETO.Unseal.Fingerprint }), false},
+}
+
+// secret is a setting that is zeroed once it is parsed.
+// Values from the environment and the YAML decoder
+// are Go strings first, which cannot be zeroed.
+type secret []byte
+
+func (s *secret) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var v string
+	if err := unmarshal(&v); nil != err {
+		return err
+	}
+
+	*s = secret(v)
+	return nil
+}
+
+func str(field func(f *file) *string) func(f *file, s string) error {
+	return func(f *file, s string) error { *field(f) = s; return nil }
+}
+
+func boolean(field func(f *file) *bool) func(f *file, s string) error {
+	return func(f *file, s string) (err error) { *field(f), err = strconv.ParseBool(s); return }
+}
+
+func integer(field func(f *file) *int) func(f *file, s string) error {
+	return func(f *file, s string) (err error) { *field(f), err = strconv.Atoi(s); return }
+}
+
+func dur(field func(f *file) *time.Duration) func(f *file, s string) error {
+	return func(f *file, s string) (err error) { *field(f), err = time.ParseDuration(s); return }
+}
+
+// flagValue records the value of a setting given on the command line,
+// to be applied after the file and environment.
+type flagValue struct {
+	*setting
+	values *[]func(f *file) error
+}
+
+func (v flagValue) String() string   { return "" }
+func (v flagValue) IsBoolFlag() bool { return nil != v.setting && v.Bool }
+
+func (v flagValue) Set(s string) error {
+	if v.Bool { // validate now for a better flag error
+		if _, err := strconv.ParseBool(s); nil != err {
+			return err
+		}
+	}
+
+	*v.values = append(*v.values, func(f *file) error { return v.setting.Set(f, s) })
+	return nil
+}
+
+// ParseConfig builds a Config from, in increasing precedence:
+// the defaults, the YAML file named by -config or NGAUTH_CONFIG,
+// NGAUTH_* variables of env (as returned by os.Environ)
+// and the command line flags in args (without the program name).
+// Unknown file fields are errors,
+// and so are NGAUTH_* variables that look like misspelled settings.
+// Other NGAUTH_* variables are ignored,
+// such as those Kubernetes sets for a service named ngauth.
+// check reports whether -check-config was given,
+// asking to validate the configuration and exit.
+//
+// The returned Config logs to stdout unless configured otherwise.
+func ParseConfig(args, env []string, output io.Writer) (c Config, check bool, err error) {
+	var (
+		path   string
+		values []func(f *file) error
+	)
+
+	fs := flag.NewFlagSet("ngauth", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&path, "config", "", "`path` to the YAML configuration file")
+	fs.BoolVar(&check, "check-config", false, "validate the configuration and exit")
+	for i := range settings {
+		if s := &settings[i]; 0 != len(s.Flag) {
+			fs.Var(flagValue{s, &values}, s.Flag, s.Usage+" (env "+EnvPrefix+s.Env+")")
+		}
+	}
+
+	if err = fs.Parse(args); nil != err {
+		return
+	}
+
+	if 0 != fs.NArg() {
+		return c, check, fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+
+	vars := map[string]string{}
+	for _, kv := range env {
+		if i := strings.IndexByte(kv, '='); i > 0 && strings.HasPrefix(kv, EnvPrefix) {
+			vars[kv[len(EnvPrefix):i]] = kv[i+1:]
+		}
+	}
+
+	if 0 == len(path) {
+		path = vars["CONFIG"]
+	}
+
+	delete(vars, "CONFIG")
+
+	var f file
+	f.PASETO.Unseal.Threshold = 2
+	defer func() { zero(f.PASETO.Key) }()
+
+	if 0 != len(path) {
+		b, err := ioutil.ReadFile(path)
+		if nil != err {
+			return c, check, err
+		}
+
+		defer zero(b) // may contain paseto.key
+		if err = yaml.UnmarshalStrict(b, &f); nil != err {
+			return c, check, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	for i := range settings {
+		s := &settings[i]
+		v, ok := vars[s.Env]
+		if !ok {
+			continue
+		}
+
+		delete(vars, s.Env)
+		if err = s.Set(&f, v); nil != err {
+			return c, check, fmt.Errorf("%s%s: %v", EnvPrefix, s.Env, err)
+		}
+	}
+
+	for k := range vars {
+		if name, ok := misspelledSetting(k); ok {
+			return c, check, fmt.Errorf("unknown environment variable %s%s, did you mean %s%s?",
+				EnvPrefix, k, EnvPrefix, name)
+		}
+	}
+
+	for _, set := range values {
+		if err = set(&f); nil != err {
+			return
+		}
+	}
+
+	c, err = f.config()
+	return
+}
+
+// CheckConfig loads the files that c refers to, as NewApp would,
+// for -check-config: the keyring
+// and the TLS certificate, key and client CAs.
+// It does not listen or wait for key shares.
+func CheckConfig(c Config) error {
+	if err := c.defaults(); nil != err {
+		return err
+	}
+
+	if 0 != len(c.TLS.CertFile) {
+		if err := (&certWatcher{TLS: c.TLS}).Reload(); nil != err {
+			return fmt.Errorf("tls: %v", err)
+		}
+	}
+
+	if 0 != len(c.PASETO.Keyring) {
+		if _, err := fpast2l.LoadKeyring(c.PASETO.Keyring); nil != err {
+			return fmt.Errorf("paseto.keyring: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// misspelledSetting returns the environment variable name of the setting
+// that name is likely a misspelling of,
+// one edit away, or two for names of at least six characters.
+func misspelledSetting(name string) (string, bool) {
+	max := 1
+	if len(name) >= 6 {
+		max = 2
+	}
+
+	for i := range settings {
+		if editDistance(name, settings[i].Env) <= max {
+			return settings[i].Env, true
+		}
+	}
+
+	return "", false
+}
+
+// editDistance returns the number of single byte insertions, deletions,
+// substitutions and transpositions of adjacent bytes
+// that turn a into b (optimal string alignment distance).
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+
+	return d[len(a)][len(b)]
+}
+
+// config validates f and converts it to a Config.
+func (f *file) config() (c Config, err error) {
+	if 0 != len(f.Log.Level) {
+		if c.Log.Level, err = zerolog.ParseLevel(f.Log.Level); nil != err {
+			return c, fmt.Errorf("log.level: %v", err)
+		}
+	}
+
+	switch f.Log.Output {
+	case "", "stdout":
+		c.Log.Output = os.Stdout
+	case "stderr":
+		c.Log.Output = os.Stderr
+	case "none":
+	default:
+		if c.Log.Output, err = os.OpenFile(f.Log.Output,
+			os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640); nil != err {
+			return c, fmt.Errorf("log.output: %v", err)
+		}
+	}
+
+	for i := range f.Binds {
+		if err = f.Binds[i].check(); nil != err {
+			return c, fmt.Errorf("binds[%d]: %v", i, err)
+		}
+	}
+
+	if (0 == len(f.TLS.CertFile)) != (0 == len(f.TLS.KeyFile)) {
+		return c, errors.New("tls: set both certFile and keyFile")
+	}
+
+	if 0 != len(f.TLS.ClientCAFile) && 0 == len(f.TLS.CertFile) {
+		return c, errors.New("tls.clientCAFile: requires certFile and keyFile")
+	}
+
+	c.TLS = f.TLS
+	if f.DrainDelay < 0 {
+		return c, errors.New("drainDelay: must not be negative")
+	}
+
+	c.Binds, c.DrainDelay = f.Binds, f.DrainDelay
+
+	p := &f.PASETO
+	sources := 0
+	for _, k := range [...]int{len(p.Key), len(p.KeyFile), len(p.Keyring), len(p.Unseal.Socket)} {
+		if 0 != k {
+			sources++
+		}
+	}
+
+	if sources > 1 {
+		return c, errors.New("paseto: set only one of key, keyFile, keyring and unseal.socket")
+	}
+
+	if p.LockKey && 0 != len(p.Keyring) {
+		return c, errors.New("paseto.lockKey: not supported with a keyring")
+	}
+
+	if 0 != len(p.Unseal.Socket) && (p.Unseal.Threshold < 2 || p.Unseal.Threshold > 255) {
+		return c, errors.New("paseto.unseal.threshold: must be between 2 and 255")
+	}
+
+	if fp := p.Unseal.Fingerprint; 0 != len(fp) && !validFingerprint(fp) {
+		return c, errors.New("paseto.unseal.fingerprint: must be 16 hex digits")
+	}
+
+	if p.KeyringInterval < 0 {
+		return c, errors.New("paseto.keyringInterval: must not be negative")
+	}
+
+	key := []byte(p.Key)
+	if 0 != len(p.KeyFile) {
+		b, err := ioutil.ReadFile(p.KeyFile)
+		if nil != err {
+			return c, fmt.Errorf("paseto.keyFile: %v", err)
+		}
+
+		defer zero(b)
+		key = bytes.TrimSpace(b)
+	}
+
+	if 0 != len(key) {
+		K, err := fpast2l.ParseKeyBytes(key)
+		if nil != err {
+			return c, fmt.Errorf("paseto.key: %v", err)
+		}
+
+		copy(c.PASETO.Key[:], K)
+		zero(K)
+	}
+
+	if err = fpast2l.CheckFooter(p.Footer); nil != err {
+		return c, fmt.Errorf("paseto.footer: %v", err)
+	}
+
+	c.PASETO.Footer = p.Footer
+	c.PASETO.Unseal.Socket = p.Unseal.Socket
+	c.PASETO.Unseal.Threshold = p.Unseal.Threshold
+	c.PASETO.Unseal.Fingerprint = strings.ToLower(p.Unseal.Fingerprint)
+	c.PASETO.LockKey = p.LockKey
+	c.PASETO.Keyring = p.Keyring
+	c.PASETO.KeyringInterval = p.KeyringInterval
+	c.PASETO.Limits = fpast2l.Limits{
+		MaxTokenSize:   p.Limits.MaxTokenSize,
+		MaxFooterSize:  p.Limits.MaxFooterSize,
+		MaxPayloadSize: p.Limits.MaxPayloadSize,
+	}
+
+	if f.Auth.Leeway < 0 {
+		return c, errors.New("auth.leeway: must not be negative")
+	}
+
+	if _, ok := proxyHeaders[f.Auth.Proxy]; !ok && 0 != len(f.Auth.Proxy) {
+		return c, fmt.Errorf("auth.proxy: must be %s or %s", ProxyNginx, ProxyTraefik)
+	}
+
+	for i, ch := range f.Auth.Claims {
+		if 0 == len(ch.Claim) || !validHeaderName(ch.Header) {
+			return c, fmt.Errorf("auth.claims[%d]: needs a claim and a valid header name", i)
+		}
+	}
+
+	if 0 != len(f.Auth.ClaimsHeader) && !validHeaderName(f.Auth.ClaimsHeader) {
+		return c, errors.New("auth.claimsHeader: invalid header name")
+	}
+
+	if f.Issue.MaxTTL < 0 {
+		return c, errors.New("issue.maxTTL: must not be negative")
+	}
+
+	ids := map[string]bool{}
+	for i, cl := range f.Issue.Clients {
+		if 0 == len(cl.ID) || ids[cl.ID] {
+			return c, fmt.Errorf("issue.clients[%d]: missing or duplicate id", i)
+		}
+
+		if 0 == len(cl.Secret) && 0 == len(cl.CommonName) {
+			return c, fmt.Errorf("issue.clients[%d]: needs a secret or commonName", i)
+		}
+
+		ids[cl.ID] = true
+	}
+
+	c.Auth, c.Issue = f.Auth, f.Issue
+	return c, nil
+}
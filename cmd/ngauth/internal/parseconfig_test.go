@@ -0,0 +1,209 @@
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/zrhmn/fpast2l"
+)
+
+func TestParseConfig(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); nil != err {
+			t.Fatal(err)
+		}
+
+		return path
+	}
+
+	const hexKey = "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f"
+	keyFile := write("key", hexKey+"\n")
+	config := write("ngauth.yaml", `
+log:
+  level: warn
+  output: none
+binds:
+  - address: ":8080"
+  - network: unix
+    address: /run/ngauth.sock
+    role: grpc
+    mode: 0600
+    owner: nobody
+paseto:
+  keyFile: `+keyFile+`
+  footer: kid
+  limits:
+    maxTokenSize: 4096
+auth:
+  realm: example
+  leeway: 30s
+  proxy: traefik
+  rules:
+    - pathPrefix: /admin
+      scopes: [admin]
+  headers:
+    tokenID: "-"
+issue:
+  maxTTL: 5m
+  clients:
+    - id: svc
+      secret: s3cret
+`)
+
+	c, check, err := ParseConfig(
+		[]string{"-config", config, "-bind", "tcp::9090,admin=127.0.0.1:9091", "-lock-key", "-check-config"},
+		[]string{"NGAUTH_BINDS=:7070", "NGAUTH_FOOTER=env", "PATH=/bin",
+			// set by Kubernetes for a service named ngauth
+			"NGAUTH_PORT=tcp://10.0.0.1:80", "NGAUTH_SERVICE_HOST=10.0.0.1", "NGAUTH_PORT_80_TCP_ADDR=10.0.0.1"},
+		ioutil.Discard)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	if !check {
+		t.Errorf("expected check")
+	}
+
+	if zerolog.WarnLevel != c.Log.Level || nil != c.Log.Output {
+		t.Errorf("expected warn level and no output, actual %v, %v", c.Log.Level, c.Log.Output)
+	}
+
+	for i, v := range [...]struct{ expected, actual interface{} }{
+		{2, len(c.Binds)},
+		{Bind{Network: "tcp", Address: ":9090"}, c.Binds[0]}, // flag over env over file
+		{Bind{Address: "127.0.0.1:9091", Role: RoleAdmin}, c.Binds[1]},
+		{"env", c.PASETO.Footer}, // env over file
+		{true, c.PASETO.LockKey},
+		{byte(0x70), c.PASETO.Key[0]},
+		{byte(0x8f), c.PASETO.Key[31]},
+		{4096, c.PASETO.Limits.MaxTokenSize},
+		{2, c.PASETO.Unseal.Threshold},
+		{"example", c.Auth.Realm},
+		{30 * time.Second, c.Auth.Leeway},
+		{ProxyTraefik, c.Auth.Proxy},
+		{"admin", c.Auth.Rules[0].Scopes[0]},
+		{"-", c.Auth.Headers.TokenID},
+		{5 * time.Minute, c.Issue.MaxTTL},
+		{"s3cret", c.Issue.Clients[0].Secret},
+	} {
+		if v.expected != v.actual {
+			t.Errorf("i=%d: expected %v, actual %v", i, v.expected, v.actual)
+		}
+	}
+
+	// defaults without a file
+	c, check, err = ParseConfig(nil, []string{"NGAUTH_KEY=" + hexKey}, ioutil.Discard)
+	if nil != err || check || os.Stdout != c.Log.Output || 0x70 != c.PASETO.Key[0] {
+		t.Errorf("expected defaults with the key from env, actual %v, %v", c, err)
+	}
+
+	c, _, err = ParseConfig([]string{"-config", write("key.yaml", "paseto: {key: "+hexKey+"}\n")}, nil, ioutil.Discard)
+	if nil != err || 0x8f != c.PASETO.Key[n-1] {
+		t.Errorf("expected the key from the file, actual %v", err)
+	}
+
+	for i, e := range [...]struct {
+		args []string
+		env  []string
+		err  string
+	}{
+		{[]string{"-config", write("a.yaml", "bnid: {}\n")}, nil, "field bnid not found"},
+		{[]string{"-config", write("b.yaml", "auth: {leway: 1s}\n")}, nil, "field leway not found"},
+		{[]string{"-config", write("proxy.yaml", "auth: {proxy: apache}\n")}, nil, "auth.proxy"},
+		{nil, []string{"NGAUTH_BIDNS=:80"}, "unknown environment variable NGAUTH_BIDNS, did you mean NGAUTH_BINDS?"},
+		{nil, []string{"NGAUTH_KEY_FIEL=k"}, "did you mean NGAUTH_KEY_FILE?"},
+		{nil, []string{"NGAUTH_KEYS=k"}, "did you mean NGAUTH_KEY?"},
+		{nil, []string{"NGAUTH_UNSEAL_TRESHOLD=3"}, "did you mean NGAUTH_UNSEAL_THRESHOLD?"},
+		{nil, []string{"NGAUTH_CONFIG=" + filepath.Join(dir, "missing")}, "no such file"},
+		{[]string{"-lock-key=maybe"}, nil, "invalid boolean value"},
+		{[]string{"extra"}, nil, "unexpected arguments"},
+		{[]string{"-log-level", "loud"}, nil, "log.level"},
+		{[]string{"-bind", "admin="}, nil, "missing address"},
+		{[]string{"-bind", "public=:80"}, nil, "unknown role"},
+		{[]string{"-config", write("d.yaml", "binds: [{network: udp, address: ':53'}]\n")}, nil,
+			"unsupported network"},
+		{[]string{"-config", write("e.yaml", "binds: [{address: ':80', mode: 0600}]\n")}, nil,
+			"only apply to unix sockets"},
+		{[]string{"-keyring", "kr", "-key-file", keyFile}, nil, "set only one of"},
+		{[]string{"-keyring", "kr", "-lock-key"}, nil, "not supported with a keyring"},
+		{[]string{"-unseal", "s", "-unseal-threshold", "1"}, nil, "threshold"},
+		{[]string{"-unseal", "s", "-unseal-fingerprint", "0123"}, nil, "paseto.unseal.fingerprint"},
+		{nil, []string{"NGAUTH_KEY=abcd"}, "paseto.key"},
+		{nil, []string{"NGAUTH_FOOTER=\x00kid"}, "paseto.footer"},
+		{[]string{"-tls-cert", "cert.pem"}, nil, "set both certFile and keyFile"},
+		{[]string{"-tls-client-ca", "ca.pem"}, nil, "requires certFile"},
+		{nil, []string{"NGAUTH_KEYRING_INTERVAL=soon"}, "NGAUTH_KEYRING_INTERVAL"},
+		{[]string{"-config", write("c.yaml", "issue: {clients: [{id: x}]}\n")}, nil,
+			"needs a secret or commonName"},
+		{[]string{"-config", write("f.yaml", "auth: {claims: [{claim: org, header: 'X Org'}]}\n")}, nil,
+			"auth.claims[0]"},
+		{[]string{"-config", write("g.yaml", "auth: {claimsHeader: 'X-Claims:'}\n")}, nil,
+			"auth.claimsHeader"},
+	} {
+		if _, _, err := ParseConfig(e.args, e.env, ioutil.Discard); nil == err {
+			t.Errorf("i=%d: expected error %q", i, e.err)
+		} else if !strings.Contains(err.Error(), e.err) {
+			t.Errorf("i=%d: expected error %q, actual %q", i, e.err, err)
+		}
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+	newTestCA(t).write(t, "ngauth", path("cert.pem"), path("key.pem"))
+	for name, content := range map[string]string{
+		"ca.pem":       "not PEM",
+		"keyring.json": `{"keys": []}`,
+		"bad.json":     `{"keys": [{"id": "a"}]}`,
+	} {
+		if err := ioutil.WriteFile(path(name), []byte(content), 0600); nil != err {
+			t.Fatal(err)
+		}
+	}
+
+	for i, c := range [...]struct {
+		cert, key, ca, keyring string
+		err                    string
+	}{
+		{"", "", "", "", ""},
+		{"cert.pem", "key.pem", "", "keyring.json", ""},
+		{"cert.pem", "missing.pem", "", "", "tls: "},
+		{"cert.pem", "cert.pem", "", "", "tls: "},
+		{"cert.pem", "key.pem", "ca.pem", "", "tls: " + errNoClientCAs.Error()},
+		{"", "", "", "missing.json", "paseto.keyring: "},
+		{"", "", "", "bad.json", "paseto.keyring: " + fpast2l.ErrBadKeyring.Error()},
+	} {
+		var cfg Config
+		for _, f := range [...]struct {
+			field *string
+			name  string
+		}{
+			{&cfg.TLS.CertFile, c.cert}, {&cfg.TLS.KeyFile, c.key},
+			{&cfg.TLS.ClientCAFile, c.ca}, {&cfg.PASETO.Keyring, c.keyring},
+		} {
+			if 0 != len(f.name) {
+				*f.field = path(f.name)
+			}
+		}
+
+		err := CheckConfig(cfg)
+		switch {
+		case 0 == len(c.err) && nil != err:
+			t.Errorf("i=%d: expected no error, actual %v", i, err)
+		case 0 != len(c.err) && (nil == err || !strings.HasPrefix(err.Error(), c.err)):
+			t.Errorf("i=%d: expected error %q, actual %v", i, c.err, err)
+		}
+	}
+}
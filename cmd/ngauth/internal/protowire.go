@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// This file implements the subset of the protobuf wire format
+// (https://protobuf.dev/programming-guides/encoding/)
+// needed to speak the Envoy ext_authz API (see extauthz.go)
+// without depending on generated code.
+
+const (
+	wireVarint = 0
+	wireI64    = 1
+	wireLen    = 2
+	wireI32    = 5
+)
+
+var errBadProtobuf = errors.New("malformed protobuf message")
+
+// pbField is a field of an encoded protobuf message.
+// For wireLen fields, Bytes holds the payload,
+// for all other wire types, Varint holds the value.
+type pbField struct {
+	Num    int
+	Type   int
+	Varint uint64
+	Bytes  []byte
+}
+
+// pbRange calls fn for each field of the encoded message b
+// until fn returns an error, which is returned,
+// or b is exhausted.
+func pbRange(b []byte, fn func(f pbField) error) error {
+	for 0 != len(b) {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 || tag>>3 == 0 {
+			return errBadProtobuf
+		}
+
+		b = b[n:]
+		f := pbField{Num: int(tag >> 3), Type: int(tag & 7)}
+
+		switch f.Type {
+		case wireVarint:
+			if f.Varint, n = binary.Uvarint(b); n <= 0 {
+				return errBadProtobuf
+			}
+		case wireI64:
+			if n = 8; len(b) < n {
+				return errBadProtobuf
+			}
+
+			f.Varint = binary.LittleEndian.Uint64(b)
+		case wireI32:
+			if n = 4; len(b) < n {
+				return errBadProtobuf
+			}
+
+			f.Varint = uint64(binary.LittleEndian.Uint32(b))
+		case wireLen:
+			k, m := binary.Uvarint(b)
+			if m <= 0 || k > uint64(len(b)-m) {
+				return errBadProtobuf
+			}
+
+			f.Bytes, n = b[m:m+int(k)], m+int(k)
+		default:
+			return errBadProtobuf
+		}
+
+		b = b[n:]
+		if err := fn(f); nil != err {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pbAppendTag appends the tag of field num of wire type typ to b.
+func pbAppendTag(b []byte, num, typ int) []byte {
+	return binary.AppendUvarint(b, uint64(num)<<3|uint64(typ))
+}
+
+// pbAppendVarint appends varint field num with value v to b.
+// Zero values are omitted, as proto3 does.
+func pbAppendVarint(b []byte, num int, v uint64) []byte {
+	if 0 == v {
+		return b
+	}
+
+	return binary.AppendUvarint(pbAppendTag(b, num, wireVarint), v)
+}
+
+// pbAppendBytes appends length-delimited field num with payload v to b.
+func pbAppendBytes(b []byte, num int, v []byte) []byte {
+	b = binary.AppendUvarint(pbAppendTag(b, num, wireLen), uint64(len(v)))
+	return append(b, v...)
+}
+
+// pbAppendString appends string field num with value s to b.
+// Empty strings are omitted, as proto3 does.
+func pbAppendString(b []byte, num int, s string) []byte {
+	if 0 == len(s) {
+		return b
+	}
+
+	b = binary.AppendUvarint(pbAppendTag(b, num, wireLen), uint64(len(s)))
+	return append(b, s...)
+}
@@ -0,0 +1,206 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/zrhmn/fpast2l"
+)
+
+// retireDelay is how long a replaced locked key is kept
+// for requests still using it, see Reload.
+const retireDelay = 5 * time.Second
+
+var errNoKey = errors.New("keys: no key configured, unseal requires a restart")
+
+// state is the part of an app replaced by Reload:
+// the keys and the settings of the handlers.
+// A state is never modified once stored,
+// so handlers load it once per request and use it without locking.
+type state struct {
+	Auth   Auth
+	Issue  Issue
+	Limits fpast2l.Limits
+
+	Keys    keyring
+	mint    func(b []byte) (string, error) // encrypts b in-place, see Issue
+	eng     *fpast2l.Engine                // single key, if not a keyring
+	watcher *fpast2l.KeyringWatcher
+	key     *fpast2l.LockedKey
+
+	// fingerprints identifies the keys in logs:
+	// a fingerprint of the single key or the IDs of a keyring.
+	fingerprints []string
+}
+
+// current returns the state in use.
+func (app *_App) current() *state {
+	return app.state.Load().(*state)
+}
+
+// newState builds the state for c, which must have its defaults set.
+// If c has no key of its own, because it was unsealed or is ephemeral,
+// the single key of cur (if non-nil) is kept.
+// The key of c is zeroed once it is locked.
+func (app *_App) newState(c *Config, cur *state) (*state, error) {
+	p := &c.PASETO
+	s := &state{Auth: c.Auth, Issue: c.Issue, Limits: p.Limits}
+	if err := fpast2l.CheckFooter(p.Footer); nil != err {
+		return nil, err
+	}
+
+	switch {
+	case 0 != len(p.Keyring):
+		w, err := fpast2l.WatchKeyring(p.Keyring, p.KeyringInterval, func(err error) {
+			app.Logger.Error().Str("event", "KEYRING").Err(err).Send()
+		})
+		if nil != err {
+			return nil, err
+		}
+
+		w.SetLimits(p.Limits)
+		s.Keys, s.watcher, s.mint = w, w, w.Encrypt
+		for _, k := range w.Keyring().Keys() {
+			s.fingerprints = append(s.fingerprints, k.ID)
+		}
+
+	case nil != cur && (0 != len(p.Unseal.Socket) || [n]byte{} == p.Key):
+		if nil == cur.eng {
+			return nil, errNoKey
+		}
+
+		s.key, s.fingerprints = cur.key, cur.fingerprints
+		s.setEngine(cur.eng.WithFooter(p.Footer).WithLimits(p.Limits))
+
+	case p.LockKey:
+		s.fingerprints = []string{fingerprint(p.Key[:])}
+		s.key = fpast2l.NewLockedKey(p.Key[:])
+		p.Key = [n]byte{}
+
+		if !s.key.Locked() {
+			app.Logger.Warn().Str("event", "LOCKKEY").
+				Msg("key memory could not be locked, using ordinary memory")
+		}
+
+		s.setEngine(fpast2l.
+			NewLocked(s.key).
+			WithFooter(p.Footer).
+			WithLimits(p.Limits))
+
+	default:
+		s.fingerprints = []string{fingerprint(p.Key[:])}
+		s.setEngine(fpast2l.
+			New(p.Key[:]).
+			WithFooter(p.Footer).
+			WithLimits(p.Limits))
+	}
+
+	return s, nil
+}
+
+// setEngine makes s decrypt and issue tokens with eng.
+func (s *state) setEngine(eng fpast2l.Engine) {
+	s.Keys, s.eng = eng, &eng
+	s.mint = func(b []byte) (string, error) { return eng.Encrypt(b), nil }
+}
+
+// close releases the resources of s that next does not share.
+// A locked key is destroyed after delay, or at once if delay <= 0,
+// since requests may still be using s.
+func (s *state) close(next *state, delay time.Duration) {
+	if nil != s.watcher {
+		s.watcher.Close()
+	}
+
+	if nil == s.key || nil != next && s.key == next.key {
+		return
+	}
+
+	if delay <= 0 {
+		s.key.Destroy()
+		return
+	}
+
+	time.AfterFunc(delay, s.key.Destroy)
+}
+
+// fingerprint returns a short identifier of key K
+// that reveals nothing about it.
+func fingerprint(K []byte) string {
+	h := sha256.New()
+	h.Write([]byte("ngauth key fingerprint\x00"))
+	h.Write(K)
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// validFingerprint reports whether s is formatted like a fingerprint,
+// in either case.
+func validFingerprint(s string) bool {
+	b, err := hex.DecodeString(s)
+	return nil == err && 8 == len(b)
+}
+
+// Reload applies c to the running app:
+// it rebuilds the keys from c, unless they were unsealed,
+// swaps them in together with the auth and issue settings,
+// and reloads the TLS certificate files.
+// Requests in flight finish with the previous state.
+//
+// Settings of listeners, logging and unseal only take effect on restart,
+// changes to them are logged.
+// On error, the app keeps serving with its current state.
+func (app *_App) Reload(c Config) error {
+	app.reloading.Lock()
+	defer app.reloading.Unlock()
+
+	if 0 != atomic.LoadInt32(&app.draining) {
+		return errDraining
+	}
+
+	if err := c.defaults(); nil != err {
+		return err
+	}
+
+	for _, r := range [...]struct {
+		name     string
+		old, new interface{}
+	}{
+		{"binds", app.Config.Binds, c.Binds},
+		{"tls", app.Config.TLS, c.TLS},
+		{"drainDelay", app.Config.DrainDelay, c.DrainDelay},
+		{"log.level", app.Config.Log.Level, c.Log.Level},
+		{"paseto.unseal", app.Config.PASETO.Unseal, c.PASETO.Unseal},
+	} {
+		if !reflect.DeepEqual(r.old, r.new) {
+			app.Logger.Warn().Str("event", "RELOAD").Str("setting", r.name).
+				Msg("change requires a restart")
+		}
+	}
+
+	cur := app.current()
+	s, err := app.newState(&c, cur)
+	if nil != err {
+		return err
+	}
+
+	app.state.Store(s)
+	cur.close(s, retireDelay)
+	app.Logger.Info().Str("event", "RELOAD").
+		Strs("oldKeys", cur.fingerprints).
+		Strs("newKeys", s.fingerprints).
+		Send()
+
+	if nil != app.certs {
+		if err := app.certs.Reload(); nil != err {
+			return err
+		}
+
+		app.LogEvent("RELOAD").Str("unit", "tls").Send()
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zrhmn/fpast2l"
+)
+
+func TestAppReload(t *testing.T) {
+	t.Parallel()
+
+	var keys [3][n]byte
+	for i := range keys {
+		if _, err := rand.Read(keys[i][:]); nil != err {
+			t.Fatal(err)
+		}
+	}
+
+	var cfg Config
+	cfg.PASETO.Key = keys[0]
+	cfg.PASETO.LockKey = true
+
+	a, err := NewApp(cfg)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	app := a.(*_App)
+	defer func() { app.current().close(nil, 0) }()
+
+	tokens := make([]string, len(keys))
+	for i := range keys {
+		tokens[i] = fpast2l.New(keys[i][:]).Encrypt([]byte(fmt.Sprintf(
+			`{"sub":"alice","aud":"api","exp":%q}`,
+			time.Now().Add(time.Hour).Format(time.RFC3339))))
+	}
+
+	status := func(token string) int {
+		r := httptest.NewRequest("GET", "/auth", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// requests keep being served while reloading
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				status(tokens[0])
+			}
+		}
+	}()
+
+	for i, c := range [...]struct {
+		key      *[n]byte
+		keyring  string
+		audience string
+		err      bool
+		statuses [3]int // for tokens
+		same     bool   // same fingerprints as before
+	}{
+		{&keys[1], "", "", false, [3]int{401, 200, 401}, false},
+		{&keys[2], "", "web", false, [3]int{401, 401, 401}, false}, // audience
+		{&keys[2], "", "api", false, [3]int{401, 401, 200}, true},
+		{&keys[0], "/nonexistent/keyring.json", "", true, [3]int{401, 401, 200}, true},
+		{nil, "", "", false, [3]int{401, 401, 200}, true}, // no key, keep the current one
+	} {
+		var next Config
+		next.PASETO.LockKey = true
+		next.PASETO.Keyring = c.keyring
+		next.Auth.Audience = c.audience
+		if nil != c.key {
+			next.PASETO.Key = *c.key
+		}
+
+		before := app.current().fingerprints
+		if err := app.Reload(next); c.err != (nil != err) {
+			t.Errorf("i=%d: expected error %t, actual %v", i, c.err, err)
+		}
+
+		if after := app.current().fingerprints; c.same != (before[0] == after[0]) {
+			t.Errorf("i=%d: expected same fingerprint %t, actual %v, %v", i, c.same, before, after)
+		}
+
+		for j, token := range tokens {
+			if s := status(token); s != c.statuses[j] {
+				t.Errorf("i=%d, j=%d: expected %d, actual %d", i, j, c.statuses[j], s)
+			}
+		}
+	}
+
+	close(done)
+	wg.Wait()
+
+	// switching from a keyring to unsealed keys needs a restart
+	dir := t.TempDir()
+	var next Config
+	next.PASETO.Keyring = filepath.Join(dir, "keyring.json")
+	next.PASETO.KeyringInterval = -1
+	if err := ioutil.WriteFile(next.PASETO.Keyring, []byte(`{"keys": [{
+		"id": "k0", "status": "active", "key": "`+fpast2l.FormatKey(keys[0][:])+`"}]}`), 0600); nil != err {
+		t.Fatal(err)
+	}
+
+	if err := app.Reload(next); nil != err {
+		t.Fatal(err)
+	}
+
+	token, err := app.current().mint([]byte(fmt.Sprintf(`{"sub":"alice","aud":"api","exp":%q}`,
+		time.Now().Add(time.Hour).Format(time.RFC3339))))
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	if s := status(token); 200 != s {
+		t.Errorf("expected 200 with the keyring, actual %d", s)
+	}
+
+	next.PASETO.Keyring = ""
+	next.PASETO.Unseal.Socket = filepath.Join(dir, "unseal.sock")
+	if err := app.Reload(next); errNoKey != err {
+		t.Errorf("expected %v, actual %v", errNoKey, err)
+	}
+}
@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/zrhmn/fpast2l"
+	"github.com/zrhmn/fpast2l/metrics"
+)
+
+// TokenAgeBuckets are histogram buckets, in seconds,
+// for the age of tokens when they are verified.
+var TokenAgeBuckets = []float64{1, 10, 60, 300, 900, 3600, 4 * 3600, 24 * 3600, 7 * 24 * 3600}
+
+// appMetrics are the metrics served on GET /metrics
+// of RoleAdmin listeners:
+//
+//	ngauth_requests_total{role, status, reason}   counter
+//	ngauth_request_duration_seconds{role}         histogram
+//	ngauth_requests_in_flight{role}               gauge
+//	ngauth_key_uses_total{key}                    counter
+//	ngauth_token_age_seconds                      histogram
+//	ngauth_tokens_issued_total{client}            counter
+//
+// where role is RoleAuth or RoleGRPC,
+// reason is "ok" or the kind of error (see reason)
+// and key is the ID of the key that decrypted the token.
+type appMetrics struct {
+	*metrics.Registry
+	requests metrics.CounterVec
+	duration metrics.HistogramVec
+	inFlight metrics.GaugeVec
+	keys     metrics.CounterVec
+	tokenAge metrics.HistogramVec
+	issued   metrics.CounterVec
+}
+
+func newAppMetrics() *appMetrics {
+	r := metrics.NewRegistry()
+	return &appMetrics{
+		Registry: r,
+		requests: metrics.NewCounterVec(r, "ngauth_requests_total",
+			"Number of authorization requests, by status and reason.",
+			"role", "status", "reason"),
+		duration: metrics.NewHistogramVec(r, "ngauth_request_duration_seconds",
+			"Time spent authorizing a request.",
+			metrics.DefaultDurationBuckets, "role"),
+		inFlight: metrics.NewGaugeVec(r, "ngauth_requests_in_flight",
+			"Number of authorization requests being served.",
+			"role"),
+		keys: metrics.NewCounterVec(r, "ngauth_key_uses_total",
+			"Number of tokens decrypted, by key ID.",
+			"key"),
+		tokenAge: metrics.NewHistogramVec(r, "ngauth_token_age_seconds",
+			"Time since a token was issued (iat) when it was verified.",
+			TokenAgeBuckets),
+		issued: metrics.NewCounterVec(r, "ngauth_tokens_issued_total",
+			"Number of tokens issued by POST /token, by client.",
+			"client"),
+	}
+}
+
+// track counts a request of role in flight
+// until the returned function is called.
+func (m *appMetrics) track(role string) func() {
+	g := m.inFlight.With(role)
+	g.Inc()
+	return g.Dec
+}
+
+// observe records the authorization request c.
+func (m *appMetrics) observe(c *core) {
+	m.requests.With(c.Role, strconv.Itoa(c.Status), reason(c.Err)).Inc()
+	m.duration.With(c.Role).Observe(time.Since(c.Epoch).Seconds())
+
+	if c.Decrypted {
+		m.keys.With(c.KeyID).Inc()
+	}
+
+	if !c.IssuedAt.IsZero() {
+		m.tokenAge.With().Observe(c.Epoch.Sub(c.IssuedAt).Seconds())
+	}
+}
+
+// reason returns a short, stable name for the error err
+// that denied a request, or "ok" if err is nil.
+func reason(err error) string {
+	switch err {
+	case nil:
+		return "ok"
+	case errMissingToken:
+		return "missing_token"
+	case errMalformedClaims:
+		return "malformed_claims"
+	case errExpired:
+		return "expired"
+	case errNotYetValid:
+		return "not_yet_valid"
+	case errWrongAudience:
+		return "wrong_audience"
+	case errWrongIssuer:
+		return "wrong_issuer"
+	case errInsufficientScope:
+		return "insufficient_scope"
+	case errBadTarget:
+		return "bad_target"
+	case errNoRule:
+		return "no_rule"
+	default:
+		return fpast2l.ErrorKind(err)
+	}
+}
@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zrhmn/fpast2l"
+)
+
+func TestAppMetrics(t *testing.T) {
+	t.Parallel()
+
+	var cfg Config
+	if _, err := rand.Read(cfg.PASETO.Key[:]); nil != err {
+		t.Fatal(err)
+	}
+
+	cfg.PASETO.Footer = "k1"
+	cfg.Auth.Rules = []Rule{{PathPrefix: "/admin", Scopes: []string{"admin"}}}
+
+	a, err := NewApp(cfg)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	app := a.(*_App)
+	eng := fpast2l.New(cfg.PASETO.Key[:]).WithFooter("k1")
+	token := func(exp, iat time.Time) string {
+		return eng.Encrypt([]byte(fmt.Sprintf(`{"sub":"alice","exp":%q,"iat":%q}`,
+			exp.Format(time.RFC3339), iat.Format(time.RFC3339))))
+	}
+
+	now := time.Now()
+	for _, c := range [...]struct{ auth, uri string }{
+		{"", "/"},
+		{"Bearer v2.local.AAAA", "/"},
+		{"Bearer " + token(now.Add(-time.Minute), now.Add(-time.Hour)), "/"},
+		{"Bearer " + token(now.Add(time.Hour), now.Add(-30*time.Second)), "/"},
+		{"Bearer " + token(now.Add(time.Hour), now.Add(-30*time.Second)), "/"},
+		{"Bearer " + token(now.Add(time.Hour), now), "/admin"},
+	} {
+		r := httptest.NewRequest("GET", "/auth", nil)
+		r.Header.Set("X-Original-URI", c.uri)
+		if 0 != len(c.auth) {
+			r.Header.Set("Authorization", c.auth)
+		}
+
+		app.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	w := httptest.NewRecorder()
+	app.admin.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if 200 != w.Code {
+		t.Fatalf("expected status 200, actual %d", w.Code)
+	}
+
+	body := w.Body.String()
+	for i, sample := range [...]string{
+		`ngauth_requests_total{role="auth",status="401",reason="missing_token"} 1`,
+		`ngauth_requests_total{role="auth",status="401",reason="bad_encoding"} 1`,
+		`ngauth_requests_total{role="auth",status="401",reason="expired"} 1`,
+		`ngauth_requests_total{role="auth",status="200",reason="ok"} 2`,
+		`ngauth_requests_total{role="auth",status="403",reason="insufficient_scope"} 1`,
+		`ngauth_request_duration_seconds_count{role="auth"} 6`,
+		`ngauth_requests_in_flight{role="auth"} 0`,
+		`ngauth_key_uses_total{key="k1"} 4`,
+		`ngauth_token_age_seconds_bucket{le="10"} 1`,
+		`ngauth_token_age_seconds_bucket{le="60"} 3`,
+		`ngauth_token_age_seconds_count 4`,
+	} {
+		if !strings.Contains(body, sample+"\n") {
+			t.Errorf("i=%d: expected %s in\n%s", i, sample, body)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+
+
+
+
+10.0.0.2
+
+10.0.0.1"
+Ϫ
+8f5a6d2c-goldenGET"/admin/x?y=1*example.com2httpsHRHTTP/2j[
+
+
+:authorityexample.com
+
+:path/admin/x?y=1
+'
+AuthorizationBearer v2.local.goldenR
+routeadmin
\ No newline at end of file
@@ -0,0 +1,17 @@
+
+
+
+
+10.0.0.2
+
+10.0.0.1"
+Ϫ
+8f5a6d2c-goldenGET
+
+:authorityexample.com
+:methodGET
+:path/admin/x?y=1'
+authorizationBearer v2.local.golden
+
+user-agentcurl/8.0"/admin/x?y=1*example.com2httpsHRHTTP/2R
+routeadmin
\ No newline at end of file
@@ -0,0 +1,5 @@
+
+insufficient scope_
+X
+T
+www-authenticate@Bearer realm="ngauth", error="insufficient_scope", scope="admin"
\ No newline at end of file
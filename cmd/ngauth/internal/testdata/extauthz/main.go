@@ -0,0 +1,108 @@
+// Command extauthz writes the golden ext_authz messages of extauthz_test.go,
+// encoded with the Go bindings of the Envoy protos:
+//
+//	cd testdata/extauthz && go run .
+package main
+
+import (
+	"os"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	auth "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	status "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const token = "Bearer v2.local.golden"
+
+func main() {
+	write("check_request_headers.pb", checkRequest(&auth.AttributeContext_HttpRequest{
+		Headers: map[string]string{
+			":authority":    "example.com",
+			":method":       "GET",
+			":path":         "/admin/x?y=1",
+			"authorization": token,
+			"user-agent":    "curl/8.0",
+		},
+	}))
+
+	write("check_request_header_map.pb", checkRequest(&auth.AttributeContext_HttpRequest{
+		HeaderMap: &core.HeaderMap{Headers: []*core.HeaderValue{
+			{Key: ":authority", RawValue: []byte("example.com")},
+			{Key: ":path", RawValue: []byte("/admin/x?y=1")},
+			{Key: "Authorization", RawValue: []byte(token)},
+		}},
+	}))
+
+	write("check_response_ok.pb", &auth.CheckResponse{
+		Status: &status.Status{},
+		HttpResponse: &auth.CheckResponse_OkResponse{OkResponse: &auth.OkHttpResponse{
+			Headers: []*core.HeaderValueOption{
+				headerValueOption("x-auth-subject", "alice"),
+				headerValueOption("x-auth-scopes", "read admin"),
+			},
+			HeadersToRemove: []string{"x-auth-issuer", "x-auth-token-id"},
+		}},
+	})
+
+	write("check_response_denied.pb", &auth.CheckResponse{
+		Status: &status.Status{Code: 7, Message: "insufficient scope"},
+		HttpResponse: &auth.CheckResponse_DeniedResponse{DeniedResponse: &auth.DeniedHttpResponse{
+			Status: &typev3.HttpStatus{Code: typev3.StatusCode_Forbidden},
+			Headers: []*core.HeaderValueOption{
+				headerValueOption("www-authenticate", `Bearer realm="ngauth", error="insufficient_scope", scope="admin"`),
+			},
+		}},
+	})
+}
+
+// checkRequest returns a CheckRequest for http
+// with the other attributes Envoy sends.
+func checkRequest(http *auth.AttributeContext_HttpRequest) *auth.CheckRequest {
+	http.Id = "8f5a6d2c-golden"
+	http.Method = "GET"
+	http.Path = "/admin/x?y=1"
+	http.Host = "example.com"
+	http.Scheme = "https"
+	http.Protocol = "HTTP/2"
+	http.Size = -1
+
+	peer := func(addr string, port uint32) *auth.AttributeContext_Peer {
+		return &auth.AttributeContext_Peer{Address: &core.Address{
+			Address: &core.Address_SocketAddress{SocketAddress: &core.SocketAddress{
+				Address:       addr,
+				PortSpecifier: &core.SocketAddress_PortValue{PortValue: port},
+			}},
+		}}
+	}
+
+	return &auth.CheckRequest{Attributes: &auth.AttributeContext{
+		Source:      peer("10.0.0.2", 51234),
+		Destination: peer("10.0.0.1", 443),
+		Request: &auth.AttributeContext_Request{
+			Time: &timestamppb.Timestamp{Seconds: 1700000000},
+			Http: http,
+		},
+		ContextExtensions: map[string]string{"route": "admin"},
+	}}
+}
+
+func headerValueOption(k, v string) *core.HeaderValueOption {
+	return &core.HeaderValueOption{
+		Header:       &core.HeaderValue{Key: k, Value: v},
+		AppendAction: core.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+	}
+}
+
+func write(name string, m proto.Message) {
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	if nil == err {
+		err = os.WriteFile(name, b, 0o644)
+	}
+
+	if nil != err {
+		panic(err)
+	}
+}
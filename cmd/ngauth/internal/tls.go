@@ -0,0 +1,190 @@
+package internal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTLSReloadInterval is how often certificate files are checked
+// for changes (see TLS).
+const DefaultTLSReloadInterval = 10 * time.Second
+
+var errNoClientCAs = errors.New("no certificates in client CA file")
+
+// certWatcher serves the certificate and client CAs of a TLS config,
+// reloading them when their files change or on Reload.
+// Reloads only affect new handshakes,
+// so listeners keep running with their connections.
+// It follows fpast2l.KeyringWatcher.
+type certWatcher struct {
+	TLS
+	onErr func(error)
+	cfg   atomic.Value // *tls.Config
+
+	mu    sync.Mutex // guards stats, serializes reloads
+	stats []os.FileInfo
+	close chan struct{}
+	once  sync.Once
+}
+
+// watchCerts loads the files of c
+// and polls them for changes every c.ReloadInterval.
+// If a reload fails, onErr is called with the error
+// and the previously loaded files stay in use.
+//
+// Close must be called to stop watching.
+func watchCerts(c TLS, onErr func(error)) (*certWatcher, error) {
+	w := &certWatcher{
+		TLS:   c,
+		onErr: onErr,
+		close: make(chan struct{}),
+	}
+
+	if err := w.Reload(); nil != err {
+		return nil, err
+	}
+
+	if c.ReloadInterval > 0 {
+		go w.watch(c.ReloadInterval)
+	}
+
+	return w, nil
+}
+
+// Config returns a tls.Config for listeners
+// that uses the currently loaded files for every handshake.
+func (w *certWatcher) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return w.cfg.Load().(*tls.Config), nil
+		},
+	}
+}
+
+// Reload loads the files and swaps them in if they are valid.
+func (w *certWatcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.reload()
+}
+
+// Close stops watching the files.
+func (w *certWatcher) Close() {
+	w.once.Do(func() { close(w.close) })
+}
+
+func (w *certWatcher) files() []string {
+	if 0 == len(w.ClientCAFile) {
+		return []string{w.CertFile, w.KeyFile}
+	}
+
+	return []string{w.CertFile, w.KeyFile, w.ClientCAFile}
+}
+
+func (w *certWatcher) reload() error {
+	// remember the files even if they fail to load,
+	// so bad files are only reported once
+	w.stats = w.stats[:0]
+	for _, path := range w.files() {
+		fi, err := os.Stat(path)
+		if nil != err {
+			return err
+		}
+
+		w.stats = append(w.stats, fi)
+	}
+
+	cert, err := tls.LoadX509KeyPair(w.CertFile, w.KeyFile)
+	if nil != err {
+		return err
+	}
+
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"},
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if 0 != len(w.ClientCAFile) {
+		b, err := ioutil.ReadFile(w.ClientCAFile)
+		if nil != err {
+			return err
+		}
+
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(b) {
+			return errNoClientCAs
+		}
+
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		if w.OptionalClientCert {
+			cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	w.cfg.Store(cfg)
+	return nil
+}
+
+// changed reports whether any of the files
+// differs from when they were last loaded.
+func (w *certWatcher) changed() bool {
+	for i, path := range w.files() {
+		fi, err := os.Stat(path)
+		if nil != err {
+			return false // keep the current files, report on next change
+		}
+
+		if i >= len(w.stats) ||
+			!fi.ModTime().Equal(w.stats[i].ModTime()) ||
+			fi.Size() != w.stats[i].Size() ||
+			!os.SameFile(fi, w.stats[i]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (w *certWatcher) watch(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-w.close:
+			return
+		case <-t.C:
+		}
+
+		w.mu.Lock()
+		err := error(nil)
+		if w.changed() {
+			err = w.reload()
+		}
+		w.mu.Unlock()
+
+		if nil != err && nil != w.onErr {
+			w.onErr(err)
+		}
+	}
+}
+
+// clientName returns the subject common name
+// of the verified TLS client certificate of r, if any.
+func clientName(r *http.Request) string {
+	if nil == r.TLS || 0 == len(r.TLS.VerifiedChains) {
+		return ""
+	}
+
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
@@ -0,0 +1,203 @@
+package internal
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zrhmn/fpast2l"
+)
+
+// testCA issues certificates for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	ca := &testCA{pool: x509.NewCertPool()}
+	ca.cert, ca.key, ca.pem = ca.issue(t, "ca", nil)
+	ca.pool.AddCert(ca.cert)
+	return ca
+}
+
+// issue returns a certificate for cn signed by ca,
+// or self-signed CA certificate if ca.cert is nil,
+// its key and its PEM encoding.
+func (ca *testCA) issue(t *testing.T, cn string, dns []string) (*x509.Certificate, *ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     dns,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	parent, signer := tmpl, key
+	if nil != ca.cert {
+		parent, signer = ca.cert, ca.key
+	} else {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// write writes a certificate for cn and its key to certFile and keyFile.
+func (ca *testCA) write(t *testing.T, cn, certFile, keyFile string) tls.Certificate {
+	_, key, certPEM := ca.issue(t, cn, []string{"localhost"})
+	der, err := x509.MarshalECPrivateKey(key)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	for _, f := range [...]struct {
+		path string
+		b    []byte
+	}{{certFile, certPEM}, {keyFile, keyPEM}} {
+		if err := ioutil.WriteFile(f.path, f.b, 0600); nil != err {
+			t.Fatal(err)
+		}
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+func TestAppTLS(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	ca := newTestCA(t)
+
+	var cfg Config
+	if _, err := rand.Read(cfg.PASETO.Key[:]); nil != err {
+		t.Fatal(err)
+	}
+
+	cfg.Binds = []Bind{{Address: "127.0.0.1:0"}}
+	cfg.TLS.CertFile = filepath.Join(dir, "cert.pem")
+	cfg.TLS.KeyFile = filepath.Join(dir, "key.pem")
+	cfg.TLS.ClientCAFile = filepath.Join(dir, "ca.pem")
+	cfg.TLS.OptionalClientCert = true
+	cfg.TLS.ReloadInterval = -1 // reload explicitly
+	cfg.Auth.Rules = []Rule{{Clients: []string{"proxy"}, Scopes: []string{"admin"}}}
+
+	ca.write(t, "ngauth-1", cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	if err := ioutil.WriteFile(cfg.TLS.ClientCAFile, ca.pem, 0600); nil != err {
+		t.Fatal(err)
+	}
+
+	a, err := NewApp(cfg)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	app := a.(*_App)
+	defer startApp(t, app)()
+
+	proxy := ca.write(t, "proxy", filepath.Join(dir, "proxy.pem"), filepath.Join(dir, "proxy.key"))
+	token := fpast2l.New(cfg.PASETO.Key[:]).Encrypt([]byte(fmt.Sprintf(
+		`{"sub":"alice","exp":%q,"scopes":"read"}`,
+		time.Now().Add(time.Hour).Format(time.RFC3339))))
+
+	// get authorizes token over TLS, with the given client certificates,
+	// and returns the status and the common name of the server certificate
+	get := func(certs []tls.Certificate) (int, string) {
+		tr := &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      ca.pool,
+			ServerName:   "localhost",
+			Certificates: certs,
+		}, ForceAttemptHTTP2: true}
+		defer tr.CloseIdleConnections()
+
+		r, _ := http.NewRequest("GET", "https://"+app.addr(RoleAuth).String()+"/auth", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := tr.RoundTrip(r)
+		if nil != err {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+		if 2 != resp.ProtoMajor {
+			t.Errorf("expected HTTP/2, actual %s", resp.Proto)
+		}
+
+		return resp.StatusCode, resp.TLS.PeerCertificates[0].Subject.CommonName
+	}
+
+	for i, c := range [...]struct {
+		certs  []tls.Certificate
+		reload string // CN of a certificate to reload before the request
+		status int
+		server string
+	}{
+		{nil, "", 200, "ngauth-1"},
+		{[]tls.Certificate{proxy}, "", 403, "ngauth-1"}, // Rule.Clients
+		{nil, "ngauth-2", 200, "ngauth-2"},
+		{[]tls.Certificate{proxy}, "", 403, "ngauth-2"},
+	} {
+		if 0 != len(c.reload) {
+			ca.write(t, c.reload, cfg.TLS.CertFile, cfg.TLS.KeyFile)
+			if err := app.Reload(cfg); nil != err {
+				t.Fatal(err)
+			}
+		}
+
+		status, server := get(c.certs)
+		if status != c.status || server != c.server {
+			t.Errorf("i=%d: expected %d from %s, actual %d from %s",
+				i, c.status, c.server, status, server)
+		}
+	}
+
+	// bad files are reported and leave the current ones in use
+	if err := ioutil.WriteFile(cfg.TLS.CertFile, []byte("garbage"), 0600); nil != err {
+		t.Fatal(err)
+	}
+
+	if err := app.certs.Reload(); nil == err {
+		t.Errorf("expected reload error")
+	}
+
+	if status, server := get(nil); 200 != status || "ngauth-2" != server {
+		t.Errorf("expected 200 from ngauth-2 after bad reload, actual %d from %s", status, server)
+	}
+}
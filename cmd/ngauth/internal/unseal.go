@@ -0,0 +1,198 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/zrhmn/fpast2l/shamir"
+)
+
+// unsealer collects key shares (see package shamir)
+// submitted over a local admin socket.
+//
+// Each line an operator writes to the socket is a share
+// formatted by shamir.FormatShare,
+// answered with either "accepted <k>/<threshold>" or "rejected: <reason>".
+// Once threshold distinct shares have been accepted,
+// the shares are combined into the key,
+// "unsealed" is written and the socket is closed.
+// If the key does not have the expected fingerprint,
+// all shares are discarded and must be submitted again.
+type unsealer struct {
+	threshold   int
+	fingerprint string
+	progress    func(shares int)
+	log         zerolog.Logger
+
+	mu     sync.Mutex
+	shares [][]byte
+	key    []byte // once unsealed
+	done   chan struct{}
+	once   sync.Once
+}
+
+// Unseal listens on the Unix socket at path,
+// readable and writable by the owner only,
+// waits for threshold key shares to be submitted
+// and returns the key they combine into.
+// If fp is set, the key must have fingerprint fp
+// (see Config.PASETO.Unseal.Fingerprint).
+// progress, if not nil, is called with the number of accepted shares
+// whenever it changes.
+func Unseal(path string, threshold int, fp string, progress func(shares int), log zerolog.Logger) (key [n]byte, err error) {
+	if threshold < 2 {
+		return key, fmt.Errorf("unseal: threshold must be at least 2")
+	}
+
+	ln, err := listenSocket(path, func(path string) error { return os.Chmod(path, 0600) })
+	if nil != err {
+		return key, err
+	}
+
+	defer ln.Close()
+
+	if nil == progress {
+		progress = func(int) {}
+	}
+
+	u := &unsealer{
+		threshold:   threshold,
+		fingerprint: fp,
+		progress:    progress,
+		log:         log,
+		done:        make(chan struct{}),
+	}
+
+	go func() {
+		<-u.done
+		ln.Close()
+	}()
+
+	if 0 == len(fp) {
+		log.Warn().Str("event", "UNSEAL").Msg("no key fingerprint set, the unsealed key is not verified")
+	}
+
+	log.Info().Str("event", "UNSEAL").Str("socket", path).
+		Int("threshold", threshold).Msg("waiting for key shares")
+
+	var wg sync.WaitGroup
+	for {
+		conn, err := ln.Accept()
+		if nil != err {
+			break // closed by finish
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			u.serve(conn)
+		}()
+	}
+
+	u.finish() // hang up on operators if ln failed
+	wg.Wait()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.discard()
+
+	if nil == u.key {
+		return key, fmt.Errorf("unseal: socket closed before unsealing")
+	}
+
+	copy(key[:], u.key)
+	zero(u.key)
+	log.Info().Str("event", "UNSEAL").Str("key", fingerprint(key[:])).Msg("unsealed")
+	return key, nil
+}
+
+// serve reads shares from conn until it is closed or unsealing is done.
+func (u *unsealer) serve(conn net.Conn) {
+	defer conn.Close()
+	go func() {
+		<-u.done
+		conn.Close()
+	}()
+
+	s := bufio.NewScanner(conn)
+	for s.Scan() {
+		reply, done := u.submit(strings.TrimSpace(s.Text()))
+		fmt.Fprintln(conn, reply)
+		if done {
+			return
+		}
+	}
+}
+
+// submit adds the formatted share s
+// and returns the reply to the operator
+// and whether unsealing is done.
+func (u *unsealer) submit(s string) (reply string, done bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if nil != u.key {
+		return "unsealed", true
+	}
+
+	share, err := shamir.ParseShare(s)
+	if nil != err || len(share) != n+1 || 0 == share[n] {
+		u.log.Warn().Str("event", "UNSEAL").Msg("rejected malformed share")
+		return "rejected: malformed share", false
+	}
+
+	for _, other := range u.shares {
+		if other[n] == share[n] {
+			zero(share)
+			u.log.Warn().Str("event", "UNSEAL").Msg("rejected duplicate share")
+			return "rejected: duplicate share", false
+		}
+	}
+
+	u.shares = append(u.shares, share)
+	u.progress(len(u.shares))
+	u.log.Info().Str("event", "UNSEAL").
+		Int("shares", len(u.shares)).Int("threshold", u.threshold).
+		Msg("accepted share")
+
+	if len(u.shares) < u.threshold {
+		return fmt.Sprintf("accepted %d/%d", len(u.shares), u.threshold), false
+	}
+
+	b, err := shamir.Combine(u.shares)
+	u.discard()
+	if nil == err && len(b) == n && (0 == len(u.fingerprint) || fingerprint(b) == u.fingerprint) {
+		u.key = b
+		u.finish()
+		return "unsealed", true
+	}
+
+	zero(b)
+	u.progress(0)
+	u.log.Error().Str("event", "UNSEAL").Msg("shares do not combine into the expected key, discarded all shares")
+	return "rejected: shares do not combine into the expected key, submit all shares again", false
+}
+
+// discard zeroes and drops the accepted shares.
+func (u *unsealer) discard() {
+	for _, share := range u.shares {
+		zero(share)
+	}
+
+	u.shares = nil
+}
+
+// finish closes the socket and all connections to it.
+func (u *unsealer) finish() { u.once.Do(func() { close(u.done) }) }
+
+// zero overwrites b with zeroes.
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,268 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/zrhmn/fpast2l/shamir"
+)
+
+func TestUnseal(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "ngauth-unseal")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var key, other [n]byte
+	for _, k := range [...][]byte{key[:], other[:]} {
+		if _, err := rand.Read(k); nil != err {
+			t.Fatal(err)
+		}
+	}
+
+	shares, err := shamir.Split(key[:], 4, 3)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	wrong, err := shamir.Split(other[:], 4, 3)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	if _, err := Unseal(filepath.Join(dir, "x.sock"), 1, "", nil, zerolog.Nop()); nil == err {
+		t.Error("expected an error for threshold 1")
+	}
+
+	path := filepath.Join(dir, "unseal.sock")
+	type result struct {
+		key [n]byte
+		err error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		k, err := Unseal(path, 3, fingerprint(key[:]), nil, zerolog.Nop())
+		done <- result{k, err}
+	}()
+
+	conn := dialUnseal(t, path)
+	defer conn.Close()
+
+	r := bufio.NewScanner(conn)
+	for i, c := range [...]struct{ share, reply string }{
+		{"zz", "rejected: malformed share"},
+		{shamir.FormatShare(shares[0][:n]), "rejected: malformed share"},
+		{shamir.FormatShare(append(make([]byte, n), 0)), "rejected: malformed share"},
+		{shamir.FormatShare(shares[0]), "accepted 1/3"},
+		{shamir.FormatShare(shares[0]), "rejected: duplicate share"},
+		{shamir.FormatShare(wrong[1]), "accepted 2/3"},
+		{shamir.FormatShare(shares[2]),
+			"rejected: shares do not combine into the expected key, submit all shares again"},
+		{shamir.FormatShare(shares[2]), "accepted 1/3"},
+		{" " + shamir.FormatShare(shares[3]) + " ", "accepted 2/3"},
+		{shamir.FormatShare(shares[1]), "unsealed"},
+	} {
+		fmt.Fprintln(conn, c.share)
+		if !r.Scan() {
+			t.Fatalf("i=%d: expected %q, actual %v", i, c.reply, r.Err())
+		}
+
+		if act := r.Text(); act != c.reply {
+			t.Errorf("i=%d: expected %q, actual %q", i, c.reply, act)
+		}
+
+		if fi, err := os.Stat(path); 0 == i && (nil != err || 0600 != fi.Mode().Perm()) {
+			t.Errorf("expected mode 0600, actual %v (%v)", fi, err)
+		}
+	}
+
+	res := <-done
+	if nil != res.err || key != res.key {
+		t.Errorf("expected the key, actual %v", res.err)
+	}
+
+	if fi, err := os.Stat(path); nil == err {
+		t.Errorf("expected the socket to be removed, actual mode %v", fi.Mode())
+	}
+
+	if r.Scan() {
+		t.Errorf("expected the connection to be closed, actual %q", r.Text())
+	}
+
+	if c, err := net.Dial("unix", path); nil == err {
+		c.Close()
+		t.Error("expected the socket to be closed")
+	}
+}
+
+func TestUnsealWithoutFingerprint(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "ngauth-unseal")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var key [n]byte
+	if _, err := rand.Read(key[:]); nil != err {
+		t.Fatal(err)
+	}
+
+	shares, err := shamir.Split(key[:], 2, 2)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "unseal.sock")
+	done := make(chan [n]byte, 1)
+	go func() {
+		k, err := Unseal(path, 2, "", nil, zerolog.Nop())
+		if nil != err {
+			t.Error(err)
+		}
+
+		done <- k
+	}()
+
+	// one operator per share
+	for i, reply := range [...]string{"accepted 1/2", "unsealed"} {
+		conn := dialUnseal(t, path)
+		fmt.Fprintln(conn, shamir.FormatShare(shares[i]))
+
+		r := bufio.NewScanner(conn)
+		if !r.Scan() || reply != r.Text() {
+			t.Errorf("i=%d: expected %q, actual %q (%v)", i, reply, r.Text(), r.Err())
+		}
+
+		conn.Close()
+	}
+
+	if k := <-done; key != k {
+		t.Error("expected the key")
+	}
+}
+
+// dialUnseal connects to the unseal socket at path
+// once it is listening.
+func dialUnseal(t *testing.T, path string) net.Conn {
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		conn, err := net.Dial("unix", path)
+		if nil == err {
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			return conn
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAppUnseal(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	var key [n]byte
+	if _, err := rand.Read(key[:]); nil != err {
+		t.Fatal(err)
+	}
+
+	shares, err := shamir.Split(key[:], 2, 2)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	var cfg Config
+	cfg.PASETO.Unseal.Socket = filepath.Join(dir, "unseal.sock")
+	cfg.PASETO.Unseal.Threshold = 2
+	cfg.Binds = []Bind{
+		{Address: "127.0.0.1:0"},
+		{Network: "unix", Address: filepath.Join(dir, "admin.sock"), Role: RoleAdmin},
+	}
+
+	type result struct {
+		app App
+		err error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		app, err := NewApp(cfg)
+		done <- result{app, err}
+	}()
+
+	conn := dialUnseal(t, cfg.PASETO.Unseal.Socket)
+	defer conn.Close()
+
+	admin := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return new(net.Dialer).DialContext(ctx, "unix", cfg.Binds[1].Address)
+		},
+	}}
+
+	get := func(path string) (int, string) {
+		resp, err := admin.Get("http://ngauth" + path)
+		if nil != err {
+			t.Fatal(err)
+		}
+
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	if status, _ := get("/healthz"); 200 != status {
+		t.Errorf("expected /healthz 200 while unsealing, actual %d", status)
+	}
+
+	r := bufio.NewScanner(conn)
+	for i, accepted := range [...]string{"0 of 2", "1 of 2"} {
+		status, body := get("/readyz")
+		if 503 != status || !strings.Contains(body, "[-]unseal failed: waiting for key shares, "+accepted) ||
+			!strings.Contains(body, "[-]keys failed: ") {
+			t.Errorf("i=%d: expected /readyz 503 while unsealing, actual %d %q", i, status, body)
+		}
+
+		fmt.Fprintln(conn, shamir.FormatShare(shares[i]))
+		if !r.Scan() {
+			t.Fatalf("i=%d: %v", i, r.Err())
+		}
+	}
+
+	res := <-done
+	if nil != res.err {
+		t.Fatal(res.err)
+	}
+
+	app := res.app.(*_App)
+	defer startApp(t, app)()
+	defer admin.CloseIdleConnections()
+
+	for i := 0; i < 100 && nil != app.checks()[1].Check(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if status, body := get("/readyz?verbose"); 200 != status || !strings.Contains(body, "[+]unseal ok\n") {
+		t.Errorf("expected /readyz 200 once unsealed, actual %d %q", status, body)
+	}
+
+	if 2 != len(app.listeners) || app.addr(RoleAdmin).String() != cfg.Binds[1].Address {
+		t.Errorf("expected the admin listener to be kept, actual %v", app.listeners)
+	}
+}
@@ -2,11 +2,14 @@ package main
 
 import (
 	"crypto/rand"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"github.com/rs/zerolog"
+	"github.com/zrhmn/fpast2l"
 	"github.com/zrhmn/fpast2l/cmd/ngauth/internal"
 )
 
@@ -26,23 +29,55 @@ func init() {
 
 func main() {
 	// IMPORTANT:
-	// chans must be unbuffered, so the receives in main and handleSignal block
-	// indefinitely.
-	sigchan := make(chan os.Signal)
-	signal.Notify(sigchan, os.Interrupt, os.Kill)
+	// finchan must be unbuffered, so the receives in main and handleSignal
+	// block indefinitely. sigchan is buffered as required by signal.Notify.
+	sigchan := make(chan os.Signal, 1)
+	hupchan := make(chan os.Signal, 1)
 
 	finchan := make(chan struct{})
 
-	cfg := internal.Config{} // call ParseConfig instead
-	cfg.Log.Output = os.Stdout
+	cfg, check, err := internal.ParseConfig(os.Args[1:], os.Environ(), os.Stderr)
+	if flag.ErrHelp == err {
+		return
+	}
 
-	if _, err := rand.Read(cfg.PASETO.Key[:]); nil != err {
+	if nil != err {
 		errlog.Fatal().Err(err).Send()
 	}
 
-	app := internal.NewApp(cfg)
+	if check {
+		if err := internal.CheckConfig(cfg); nil != err {
+			errlog.Fatal().Err(err).Send()
+		}
+
+		errlog.Info().Msg("configuration ok")
+		return
+	}
+
+	if 0 == len(cfg.PASETO.Keyring) && 0 == len(cfg.PASETO.Unseal.Socket) &&
+		[fpast2l.KeySize]byte{} == cfg.PASETO.Key {
+		errlog.Warn().Msg("no key configured, using an ephemeral random key")
+		if _, err := rand.Read(cfg.PASETO.Key[:]); nil != err {
+			errlog.Fatal().Err(err).Send()
+		}
+	}
+
+	app, err := internal.NewApp(cfg)
+	if nil != err {
+		errlog.Fatal().Err(err).Send()
+	}
+
+	if cfg.PASETO.LockKey {
+		cfg.PASETO.Key = [fpast2l.KeySize]byte{} // app holds a locked copy
+	}
+
+	// notify only now, so signals still terminate a pending unseal
+	signal.Notify(sigchan, os.Interrupt, os.Kill)
+	signal.Notify(hupchan, syscall.SIGHUP)
+
 	go handleAppErrs(finchan, app)
 	go handleSignal(sigchan, app)
+	go handleReload(hupchan, app)
 
 	app.Start()
 	<-finchan // wait for finish and then return (exit successfully)
@@ -58,6 +93,31 @@ func handleSignal(sigchan <-chan os.Signal, app internal.App) {
 	os.Exit(127)    // forced exit
 }
 
+// handleReload re-reads the configuration on every signal
+// and applies it to app, which keeps serving on errors.
+func handleReload(hupchan <-chan os.Signal, app internal.App) {
+	for sig := range hupchan {
+		errlog.Info().Str("signal", sig.String()).Send()
+
+		cfg, _, err := internal.ParseConfig(os.Args[1:], os.Environ(), os.Stderr)
+		if nil != err {
+			errlog.Error().Err(err).Msg("reload failed")
+			continue
+		}
+
+		// logging is not reloaded, close a newly opened log file
+		if f, ok := cfg.Log.Output.(*os.File); ok && os.Stdout != f && os.Stderr != f {
+			f.Close()
+		}
+
+		if err := app.Reload(cfg); nil != err {
+			errlog.Error().Err(err).Msg("reload failed")
+		}
+
+		cfg.PASETO.Key = [fpast2l.KeySize]byte{} // app holds its own copy
+	}
+}
+
 func handleAppErrs(finchan chan<- struct{}, app internal.App) {
 	errchan := app.Errs()
 	err := error(nil)
@@ -49,10 +49,10 @@ func app(c *config) App {
 	return &app
 }
 
-func (app *_App) Start()               {}
-func (app *_App) Stop()                {}
-func (app *_App) Errors() <-chan error { return app.errs }
-func (app *_App) ServeHTTP(_ http.ResponseWriter, _ *http.Request)
+func (app *_App) Start()                                           {}
+func (app *_App) Stop()                                            {}
+func (app *_App) Errors() <-chan error                             { return app.errs }
+func (app *_App) ServeHTTP(_ http.ResponseWriter, _ *http.Request) {}
 
 func (app *_App) addListeners(addrs []Addr) {
 	for _, addr := range addrs {
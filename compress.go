@@ -0,0 +1,106 @@
+package fpast2l
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"sync"
+)
+
+// DefaultMaxInflateSize is the default upper bound
+// on the size of a decompressed payload.
+const DefaultMaxInflateSize = 64 << 10
+
+var (
+	errBadCompressionLevel = internal("bad compression level")
+
+	// flateWriters holds a pool of *flate.Writer per compression level,
+	// indexed by level - flate.HuffmanOnly.
+	flateWriters [flate.BestCompression - flate.HuffmanOnly + 1]sync.Pool
+
+	flateReaders = sync.Pool{}
+	bufferPool   = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+)
+
+// checkCompressionLevel panics if level
+// is not a valid compress/flate compression level.
+func checkCompressionLevel(level int) {
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		panic(errBadCompressionLevel)
+	}
+}
+
+// deflate compresses b at the given level
+// and writes the result back into b
+// if the compressed payload is smaller than b.
+//
+// It returns the compressed payload as r and true,
+// or b and false if compressing did not make b smaller.
+func deflate(b []byte, level int) (r []byte, ok bool) {
+	if 0 == len(b) {
+		return b, false
+	}
+
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buf)
+	buf.Reset()
+
+	pool := &flateWriters[level-flate.HuffmanOnly]
+	w, _ := pool.Get().(*flate.Writer)
+	if nil == w {
+		var err error
+		if w, err = flate.NewWriter(buf, level); nil != err {
+			panic(AsError(err))
+		}
+	} else {
+		w.Reset(buf)
+	}
+	defer pool.Put(w)
+
+	if _, err := w.Write(b); nil != err {
+		panic(AsError(err))
+	}
+
+	if err := w.Close(); nil != err {
+		panic(AsError(err))
+	}
+
+	if buf.Len() >= len(b) {
+		return b, false
+	}
+
+	return b[:copy(b, buf.Bytes())], true
+}
+
+// inflate decompresses b,
+// appends the result to p and returns the appended part as r,
+// or an error if b is not valid DEFLATE data
+// or decompresses to more than max bytes.
+//
+// b may share memory with the extra capacity of p.
+func inflate(p, b []byte, max int) (r []byte, err error) {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buf)
+	buf.Reset()
+
+	fr, _ := flateReaders.Get().(io.ReadCloser)
+	if nil == fr {
+		fr = flate.NewReader(bytes.NewReader(b))
+	} else if err = fr.(flate.Resetter).Reset(bytes.NewReader(b), nil); nil != err {
+		return nil, ErrBadCompression
+	}
+	defer flateReaders.Put(fr)
+
+	n, err := buf.ReadFrom(io.LimitReader(fr, int64(max)+1))
+	if nil != err {
+		return nil, ErrBadCompression
+	}
+
+	if n > int64(max) {
+		return nil, ErrPayloadTooLarge
+	}
+
+	k := len(p)
+	p = append(p, buf.Bytes()...)
+	return p[k:], nil
+}
@@ -0,0 +1,183 @@
+package fpast2l
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func Test_deflate(t *testing.T) {
+	t.Parallel()
+
+	for i, b := range [...][]byte{
+		nil, {}, {0},
+		randomBytes(make([]byte, 64)),
+		permissionsPayload(8),
+		permissionsPayload(64),
+	} {
+		b0 := copyBuffer(b)
+		r, ok := deflate(b0, flate.DefaultCompression)
+
+		if !ok {
+			if !bytes.Equal(r, b) {
+				t.Errorf("i=%d: expected b to be untouched", i)
+			}
+
+			continue
+		}
+
+		if len(r) >= len(b) {
+			t.Errorf("i=%d: expected len(r) < %d, actual %d", i, len(b), len(r))
+		}
+
+		if &r[0] != &b0[0] {
+			t.Errorf("i=%d: b was relocated", i)
+		}
+
+		p := []byte("prefix")
+		q, err := inflate(p, r, len(b))
+		if nil != err {
+			t.Fatalf("i=%d: %v", i, err)
+		}
+
+		if !bytes.Equal(q, b) {
+			exp := hex.EncodeToString(b)
+			act := hex.EncodeToString(q)
+			t.Errorf("i=%d: expected q = Hex(%q), actual Hex(%q)", i, exp, act)
+		}
+
+		if _, err = inflate(nil, r, len(b)-1); err != ErrPayloadTooLarge {
+			t.Errorf("i=%d: expected ErrPayloadTooLarge, actual %v", i, err)
+		}
+	}
+
+	if _, err := inflate(nil, []byte{0xff, 0xff}, 1<<10); err != ErrBadCompression {
+		t.Errorf("expected ErrBadCompression, actual %v", err)
+	}
+}
+
+func TestEngineCompression(t *testing.T) {
+	t.Parallel()
+
+	k := randomBytes(make([]byte, KeySize))
+	f := randomString(16)
+	eng := New(k).WithFooter(f).WithCompression(flate.BestCompression)
+
+	for i, c := range [...]struct {
+		b []byte
+		z bool
+	}{
+		{nil, false},
+		{randomBytes(make([]byte, 64)), false},
+		{permissionsPayload(64), true},
+	} {
+		s := eng.Encrypt(copyBuffer(c.b))
+
+		_, rf, err := rPASTDecrypt(k, s)
+		if nil != err {
+			t.Fatalf("i=%d: %v", i, err)
+		}
+
+		if exp := f; c.z {
+			if exp = flaggedFooters(f)[flagDeflate]; rf != exp {
+				t.Errorf("i=%d: expected f = %q, actual %q", i, exp, rf)
+			}
+		} else if rf != exp {
+			t.Errorf("i=%d: expected f = %q, actual %q", i, exp, rf)
+		}
+
+		// decompression does not depend on WithCompression
+		r, err := New(k).Decrypt(nil, s)
+		if nil != err {
+			t.Fatalf("i=%d: %v", i, err)
+		}
+
+		if !bytes.Equal(r, c.b) {
+			exp := hex.EncodeToString(c.b)
+			act := hex.EncodeToString(r)
+			t.Errorf("i=%d: expected r = Hex(%q), actual Hex(%q)", i, exp, act)
+		}
+	}
+
+	// decompression bomb
+	bomb := make([]byte, DefaultMaxInflateSize+1)
+	s := eng.Encrypt(bomb)
+	if _, err := New(k).Decrypt(nil, s); err != ErrPayloadTooLarge {
+		t.Errorf("expected ErrPayloadTooLarge, actual %v", err)
+	}
+
+	r, err := New(k).WithMaxInflateSize(len(bomb)).Decrypt(nil, s)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	if len(r) != len(bomb) {
+		t.Errorf("expected len(r) = %d, actual %d", len(bomb), len(r))
+	}
+
+	// flagged, but not compressed
+	s = rPASTEncrypt(k, randomBytes(make([]byte, 64)), flaggedFooters(f)[flagDeflate])
+	if _, err := New(k).Decrypt(nil, s); err != ErrBadCompression {
+		t.Errorf("expected ErrBadCompression, actual %v", err)
+	}
+}
+
+func BenchmarkEngineCompression(b *testing.B) {
+	k := randomBytes(make([]byte, KeySize))
+
+	for _, n := range [...]int{8, 32, 64} {
+		B := permissionsPayload(n)
+
+		for _, c := range [...]struct {
+			name string
+			eng  Engine
+		}{
+			{"None", New(k)},
+			{"BestSpeed", New(k).WithCompression(flate.BestSpeed)},
+			{"Default", New(k).WithCompression(flate.DefaultCompression)},
+		} {
+			eng := c.eng
+			s := eng.Encrypt(copyBuffer(B))
+			p := make([]byte, 0, 2*len(B))
+			name := fmt.Sprintf("%dB/%s", len(B), c.name)
+
+			b.Run(name+"/Encrypt", func(b *testing.B) {
+				b0 := make([]byte, len(B), 2*len(B))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					copy(b0, B)
+					_ = eng.Encrypt(b0)
+				}
+
+				b.ReportMetric(float64(len(s)), "token-bytes")
+			})
+
+			b.Run(name+"/Decrypt", func(b *testing.B) {
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					_, _ = eng.Decrypt(p, s)
+				}
+
+				b.ReportMetric(float64(len(s)), "token-bytes")
+			})
+		}
+	}
+}
+
+// permissionsPayload returns a JSON claims payload
+// with n permission strings, typical of large tokens.
+func permissionsPayload(n int) []byte {
+	perms := make([]string, n)
+	for i := range perms {
+		perms[i] = fmt.Sprintf("%q", fmt.Sprintf(
+			"projects/%s/resources/%d:read,write", randomString(6), i))
+	}
+
+	return []byte(`{"sub":"` + randomString(16) + `","perms":[` +
+		strings.Join(perms, ",") + `]}`)
+}
@@ -3,7 +3,6 @@ package fpast2l
 import (
 	"crypto/cipher"
 	"strings"
-	"unsafe"
 )
 
 // decrypt deciphers the ciphertext b
@@ -33,6 +32,18 @@ func decrypt(ci cipher.AEAD, b []byte, a pae) (r []byte, err error) {
 // along with assembled pre-authentication encoding a,
 // or an error if s cannot be parsed.
 func decode(p []byte, s string) (b []byte, a pae, err error) {
+	return decodeLimited(p, s, Limits{}, stdB64)
+}
+
+// decodeLimited is decode
+// failing with ErrTokenTooLarge before p is extended
+// if s exceeds l,
+// decoding the nonce and ciphertext with c.
+func decodeLimited(p []byte, s string, l Limits, c b64Codec) (b []byte, a pae, err error) {
+	if err := l.checkToken(len(s)); nil != err {
+		return nil, nil, err
+	}
+
 	n := len(s)
 	if n < headerSize || s[:headerSize] != header {
 		return nil, nil, ErrBadHeader
@@ -66,6 +77,9 @@ func decode(p []byte, s string) (b []byte, a pae, err error) {
 
 	k := b64.DecodedLen(n)
 	m := b64.DecodedLen(len(f))
+	if err := l.checkParts(k-tagSize, m); nil != err {
+		return nil, nil, err
+	}
 
 	p, b = extend(p, k+minPAESize+m)
 	b = b[len(p):][:k]
@@ -73,7 +87,7 @@ func decode(p []byte, s string) (b []byte, a pae, err error) {
 
 	a.init(m)
 
-	if _, err := a.setNonceB64(x); nil != err {
+	if _, err := a.setNonceB64(x, c); nil != err {
 		return nil, nil, ErrBadEncoding
 	}
 
@@ -81,7 +95,7 @@ func decode(p []byte, s string) (b []byte, a pae, err error) {
 		return nil, nil, ErrBadEncoding
 	}
 
-	if _, err := b64.Decode(b, *(*[]byte)(unsafe.Pointer(&s))); nil != err {
+	if _, err := c.decode(b, s); nil != err {
 		return nil, nil, ErrBadEncoding
 	}
 
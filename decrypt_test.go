@@ -217,3 +217,62 @@ func Test_decode(t *testing.T) {
 		}
 	}
 }
+
+func Test_decodeB64(t *testing.T) {
+	t.Parallel()
+
+	var cases []string
+	for _, n := range [...]int{0, 1, 2, 3, 64, 191, 192, 193, 500, 1 << 10} {
+		s := b64.EncodeToString(randomBytes(make([]byte, n)))
+		cases = append(cases, s)
+		if 0 != len(s) {
+			cases = append(cases,
+				s[:len(s)-1]+"=",                     // bad last quantum
+				s[:len(s)/2]+"+"+s[len(s)/2+1:],      // not URL-safe
+				s[:len(s)/2]+"\n"+s[len(s)/2:],       // newline
+				s[:len(s)/2]+"\r\n"+s[len(s)/2:]+"!", // newline and bad
+			)
+		}
+	}
+
+	for i, s := range cases {
+		exp, expErr := b64.DecodeString(s)
+
+		act := make([]byte, b64.DecodedLen(len(s)))
+		n, err := decodeB64(act, s)
+		if (nil == err) != (nil == expErr) {
+			t.Errorf("i=%d: expected err = %v, actual %v", i, expErr, err)
+			continue
+		}
+
+		if nil == err && !bytes.Equal(act[:n], exp) {
+			t.Errorf("i=%d: expected Hex(%q), actual Hex(%q)", i,
+				hex.EncodeToString(exp), hex.EncodeToString(act[:n]))
+		}
+	}
+}
+
+// BenchmarkDecodeB64 compares decodeB64 against b64.DecodeString;
+// run it with and without the purego build tag to compare builds.
+func BenchmarkDecodeB64(b *testing.B) {
+	s := b64.EncodeToString(randomBytes(make([]byte, sysPageSize)))
+	p := make([]byte, b64.DecodedLen(len(s)))
+
+	b.Run("decodeB64", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(s)))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			_, _ = decodeB64(p, s)
+		}
+	})
+
+	b.Run("DecodeString", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(s)))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			_, _ = b64.DecodeString(s)
+		}
+	})
+}
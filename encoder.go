@@ -0,0 +1,122 @@
+package fpast2l
+
+import "time"
+
+// Encoder creates PASETO v2 local tokens using an Engine,
+// keeping its own scratch space for the payload,
+// the pre-authentication encoding and the encoded token.
+// Once the scratch space has grown to fit the largest token,
+// Encrypt does not allocate.
+//
+// Unlike Engine, an Encoder must not be used concurrently.
+// Use one Encoder per goroutine.
+type Encoder struct {
+	eng Engine
+	buf []byte // payload, tag and PAE
+	out []byte // encoded token
+}
+
+// NewEncoder returns a new Encoder that encrypts using eng.
+func NewEncoder(eng Engine) *Encoder {
+	if nil == eng.ci {
+		panic(ErrEngNotInitialized)
+	}
+
+	return &Encoder{eng: eng}
+}
+
+// Encrypt creates a new PASETO v2 local token
+// from the payload contained in b and returns it.
+// Unlike Engine.Encrypt, b is not modified.
+//
+// The returned token is only valid until the next call to Encrypt.
+func (e *Encoder) Encrypt(b []byte) []byte {
+	e.out = e.appendEncrypt(e.out[:0], b)
+	return e.out
+}
+
+// appendEncrypt is Encrypt
+// appending the token to p and returning it.
+func (e *Encoder) appendEncrypt(p, b []byte) []byte {
+	if nil == e.eng.obs {
+		return e.appendToken(p, b)
+	}
+
+	t := time.Now()
+	r := e.appendToken(p, b)
+	e.eng.obs.Observe(Observation{
+		Op:        OpEncrypt,
+		Size:      len(b),
+		TokenSize: len(r) - len(p),
+		Duration:  time.Since(t),
+	})
+
+	return r
+}
+
+// appendToken implements appendEncrypt.
+func (e *Encoder) appendToken(p, b []byte) []byte {
+	eng := e.eng
+
+	n := len(b)
+	if eng.pad {
+		n = paddedSize(n+1, eng.buckets)
+	}
+
+	// the PAE template with all flags set is the largest
+	n += tagSize + len(eng.t.pae[len(eng.t.pae)-1])
+	if cap(e.buf) < n {
+		e.buf = make([]byte, n)
+	}
+
+	c, a, flags := eng.seal(append(e.buf[:0], b...))
+	return appendToken(p, c, a, eng.t.f64[flags], eng.codec)
+}
+
+// Decoder decrypts PASETO v2 local tokens using an Engine,
+// keeping its own scratch space for the decoded token and the plaintext.
+// Once the scratch space has grown to fit the largest token,
+// Decrypt does not allocate.
+//
+// Unlike Engine, a Decoder must not be used concurrently.
+// Use one Decoder per goroutine.
+type Decoder struct {
+	eng Engine
+	buf []byte
+}
+
+// NewDecoder returns a new Decoder that decrypts using eng.
+func NewDecoder(eng Engine) *Decoder {
+	if nil == eng.ci {
+		panic(ErrEngNotInitialized)
+	}
+
+	return &Decoder{eng: eng}
+}
+
+// Decrypt parses and decrypts s as a PASETO v2 local token
+// and returns the plaintext.
+// (Also see Engine.Decrypt.)
+//
+// The returned plaintext is only valid until the next call to Decrypt.
+func (d *Decoder) Decrypt(s string) ([]byte, error) {
+	if err := d.eng.limits.checkToken(len(s)); nil != err {
+		return nil, err // before growing d.buf for s
+	}
+
+	// the decoded token and the PAE never exceed len(s) + minPAESize
+	if n := len(s) + minPAESize; cap(d.buf) < n {
+		d.buf = make([]byte, 0, n)
+	}
+
+	b, err := d.eng.Decrypt(d.buf[:0], s)
+	if nil != err {
+		return nil, err
+	}
+
+	if cap(b) > cap(d.buf) { // grown by decompression
+		d.buf = b[:0]
+	}
+
+	return b, nil
+}
@@ -0,0 +1,121 @@
+package fpast2l
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/hex"
+	"testing"
+)
+
+func TestEncoder(t *testing.T) {
+	t.Parallel()
+
+	k := randomBytes(make([]byte, KeySize))
+	f := randomString(32)
+
+	for i, eng := range [...]Engine{
+		New(k),
+		New(k).WithFooter(f),
+		New(k).WithFooter(f).WithPadding(64, 256),
+		New(k).WithFooter(f).WithCompression(flate.BestSpeed).WithPadding(),
+	} {
+		enc, dec := NewEncoder(eng), NewDecoder(eng)
+		for j, b := range [...][]byte{
+			nil, {}, {0},
+			randomBytes(make([]byte, 64)),
+			permissionsPayload(32),
+			randomBytes(make([]byte, 1<<10)),
+		} {
+			b0 := copyBuffer(b)
+			s := string(enc.Encrypt(b0))
+
+			if !bytes.Equal(b0, b) {
+				t.Errorf("i=%d: payload was modified", i*10+j)
+			}
+
+			r, rf, err := rPASTDecrypt(k, s)
+			if nil != err {
+				t.Fatalf("i=%d: %v", i*10+j, err)
+			}
+
+			if _, flags := splitFlags([]byte(rf)); 0 == flags && !bytes.Equal(r, b) {
+				exp := hex.EncodeToString(b)
+				act := hex.EncodeToString(r)
+				t.Errorf("i=%d: expected r = Hex(%q), actual Hex(%q)",
+					i*10+j, exp, act)
+			}
+
+			if r, err = dec.Decrypt(s); nil != err {
+				t.Fatalf("i=%d: %v", i*10+j, err)
+			}
+
+			if !bytes.Equal(r, b) {
+				exp := hex.EncodeToString(b)
+				act := hex.EncodeToString(r)
+				t.Errorf("i=%d: expected r = Hex(%q), actual Hex(%q)",
+					i*10+j, exp, act)
+			}
+		}
+	}
+
+	dec := NewDecoder(New(k))
+	if _, err := dec.Decrypt(header); err != ErrBadEncoding {
+		t.Errorf("expected ErrBadEncoding, actual %v", err)
+	}
+}
+
+func TestEncoderAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocation counts are unreliable with -race")
+	}
+
+	k := randomBytes(make([]byte, KeySize))
+	b := randomBytes(make([]byte, 64))
+
+	for i, eng := range [...]Engine{
+		New(k).WithFooter(randomString(32)),
+		New(k).WithFooter(randomString(32)).WithPadding(),
+		New(k).WithCompression(flate.BestSpeed),
+	} {
+		enc, dec := NewEncoder(eng), NewDecoder(eng)
+		s := string(enc.Encrypt(b))
+		_, _ = dec.Decrypt(s) // grow scratch space
+
+		if n := testing.AllocsPerRun(100, func() { enc.Encrypt(b) }); 0 != n {
+			t.Errorf("i=%d: expected Encrypt 0 allocs, actual %v", i, n)
+		}
+
+		n := testing.AllocsPerRun(100, func() {
+			if _, err := dec.Decrypt(s); nil != err {
+				panic(err)
+			}
+		})
+
+		if 0 != n && !eng.zip { // inflate allocates a flate reader state
+			t.Errorf("i=%d: expected Decrypt 0 allocs, actual %v", i, n)
+		}
+	}
+}
+
+func BenchmarkEncoder(b *testing.B) {
+	eng := New(randomBytes(make([]byte, KeySize)))
+	enc, dec := NewEncoder(eng), NewDecoder(eng)
+	B := randomBytes(make([]byte, 32))
+	s := string(enc.Encrypt(B))
+
+	b.Run("Encrypt", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			_ = enc.Encrypt(B)
+		}
+	})
+
+	b.Run("Decrypt", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			_, _ = dec.Decrypt(s)
+		}
+	})
+}
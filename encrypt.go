@@ -4,7 +4,6 @@ import (
 	"crypto/cipher"
 	"encoding/base64"
 	"encoding/binary"
-	"unsafe"
 
 	"github.com/aead/poly1305"
 	"golang.org/x/crypto/chacha20poly1305"
@@ -49,7 +48,21 @@ func encrypt(ci cipher.AEAD, b []byte, a pae) (r []byte) {
 // encode converts the ciphertext in b
 // and the nonce and footer from pre-authentication encoding a
 // into a PASETO v2 local token.
-func encode(b []byte, a pae) string {
+func encode(b []byte, a pae) string { return encodeFooter(b, a, "", stdB64) }
+
+// encodeFooter is encode
+// with F as the base64 encoding of the footer of a,
+// unless F is empty,
+// encoding the nonce and ciphertext with c.
+func encodeFooter(b []byte, a pae, F string, c b64Codec) string {
+	return stringOf(appendToken(nil, b, a, F, c))
+}
+
+// appendToken is encodeFooter
+// appending the token to p and returning it as r.
+//
+// p is reallocated only if it lacks the capacity for the token.
+func appendToken(p, b []byte, a pae, F string, c b64Codec) (r []byte) {
 	a.checkLength()
 	if len(b) < tagSize {
 		panic(errBadTagSize)
@@ -63,19 +76,25 @@ func encode(b []byte, a pae) string {
 		n += 1 + b64.EncodedLen(len(f))
 	}
 
-	sb := make([]byte, n)
+	p, r = extend(p, n)
+	sb := r[len(p):]
+
 	n = copy(sb, header)
-	b64.Encode(sb[n:], a.getNonce())
+	c.encode(sb[n:], a.getNonce())
 	n += b64NonceSize
-	b64.Encode(sb[n:], b)
+	c.encode(sb[n:], b)
 	n += k
 
 	if 0 != len(f) {
 		sb[n] = '.'
 		n++
 
-		b64.Encode(sb[n:], f)
+		if 0 != len(F) {
+			copy(sb[n:], F)
+		} else {
+			b64.Encode(sb[n:], f)
+		}
 	}
 
-	return *(*string)(unsafe.Pointer(&sb))
+	return r
 }
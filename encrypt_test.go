@@ -82,6 +82,27 @@ func Test_encode(t *testing.T) {
 			if s != q {
 				t.Errorf("i=%d: expected s = %q, actual %q", i*10+j, q, s)
 			}
+
+			F := b64.EncodeToString(p.getFooter())
+			if s = encodeFooter(b, p, F, stdB64); s != q {
+				t.Errorf("i=%d: expected s = %q, actual %q", i*10+j, q, s)
+			}
 		}
 	}
 }
+
+func Test_stringOf(t *testing.T) {
+	t.Parallel()
+
+	b := []byte("v2.local.")
+	s := stringOf(b)
+	if s != "v2.local." {
+		t.Fatalf("expected %q, actual %q", "v2.local.", s)
+	}
+
+	// without package unsafe, s must be a copy
+	b[0] = 'x'
+	if shared := s[0] == 'x'; shared == pureGo {
+		t.Errorf("expected shared = %v, actual %v", !pureGo, shared)
+	}
+}
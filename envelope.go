@@ -0,0 +1,137 @@
+package fpast2l
+
+import (
+	"bytes"
+	"crypto/rand"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// wrappedKeyPrefix starts the footers of Envelope tokens,
+// followed by the wrapped data key,
+// so wrapped keys are never taken for footer flags (see CheckFooter).
+const wrappedKeyPrefix = "wk:"
+
+// KeyWrapper wraps and unwraps data keys under a master key
+// that it never reveals, e.g. a key held by a KMS.
+//
+// UnwrapKey must not modify wrapped
+// and must return a newly allocated data key.
+type KeyWrapper interface {
+	WrapKey(dk []byte) (wrapped []byte, err error)
+	UnwrapKey(wrapped []byte) (dk []byte, err error)
+}
+
+// Envelope generates PASETO v2 local tokens using envelope encryption.
+// Each token (or batch of tokens) is encrypted with a random data key
+// and the data key, wrapped by a KeyWrapper, is carried in the footer.
+//
+// Like Engine, Envelope can be used concurrently.
+type Envelope struct {
+	kw     KeyWrapper
+	limits Limits
+	codec  b64Codec
+}
+
+// NewEnvelope constructs and returns a new Envelope
+// that wraps data keys using kw.
+func NewEnvelope(kw KeyWrapper) Envelope { return Envelope{kw: kw} }
+
+// WithLimits returns a copy of Envelope
+// whose Decrypt rejects tokens exceeding l
+// (see Engine.WithLimits).
+func (env Envelope) WithLimits(l Limits) Envelope { env.limits = l; return env }
+
+// WithConstantTimeBase64 returns a copy of Envelope
+// whose batches and Decrypt encode and decode tokens
+// in constant time
+// (see Engine.WithConstantTimeBase64).
+func (env Envelope) WithConstantTimeBase64() Envelope { env.codec = ctB64; return env }
+
+// NewBatch generates a new data key,
+// wraps it and returns an Engine
+// that encrypts with the data key
+// and has the wrapped data key as footer,
+// prefixed with "wk:".
+//
+// All tokens generated by the returned Engine share the same data key.
+// They can be decrypted with Envelope.Decrypt.
+func (env Envelope) NewBatch() (Engine, error) {
+	if nil == env.kw {
+		panic(ErrEngNotInitialized)
+	}
+
+	var dk [KeySize]byte
+	defer zero(dk[:])
+
+	if _, err := rand.Read(dk[:]); nil != err {
+		return Engine{}, AsError(err)
+	}
+
+	f, err := env.kw.WrapKey(dk[:])
+	if nil != err {
+		return Engine{}, AsError(err)
+	}
+
+	eng := New(dk[:]).WithFooter(wrappedKeyPrefix + string(f))
+	eng.codec = env.codec
+	return eng, nil
+}
+
+// Encrypt creates and returns a new PASETO v2 local token
+// from the payload contained in b
+// using a fresh data key.
+// As with Engine.Encrypt, b is encrypted in-place.
+func (env Envelope) Encrypt(b []byte) (string, error) {
+	eng, err := env.NewBatch()
+	if nil != err {
+		return "", err
+	}
+
+	return eng.Encrypt(b), nil
+}
+
+// Decrypt parses s as a PASETO v2 local token,
+// unwraps the data key from its footer
+// and uses it to decrypt s.
+// If successful, resulting plaintext is appended to p and returned.
+// (Also see Engine.Decrypt.)
+func (env Envelope) Decrypt(p []byte, s string) (b []byte, err error) {
+	if nil == env.kw {
+		panic(ErrEngNotInitialized)
+	}
+
+	b, a, err := decodeLimited(p, s, env.limits, env.codec)
+	if nil != err {
+		return nil, err
+	}
+
+	f, flags := splitFlags(a.getFooter())
+	if !bytes.HasPrefix(f, []byte(wrappedKeyPrefix)) {
+		return nil, ErrBadWrappedKey
+	}
+
+	dk, err := env.kw.UnwrapKey(f[len(wrappedKeyPrefix):])
+	if nil != err || len(dk) != KeySize {
+		return nil, ErrBadWrappedKey
+	}
+
+	ci, err := chacha20poly1305.NewX(dk)
+	zero(dk)
+	if nil != err {
+		return nil, ErrBadWrappedKey
+	}
+
+	if b, err = decrypt(ci, b, a); nil != err || 0 == flags {
+		return b, err
+	}
+
+	return restore(p, b, flags, env.limits.maxInflateSize(DefaultMaxInflateSize))
+}
+
+// zero overwrites b with zeroes.
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
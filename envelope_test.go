@@ -0,0 +1,205 @@
+package fpast2l
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestEnvelope(t *testing.T) {
+	t.Parallel()
+
+	mk := randomBytes(make([]byte, KeySize))
+	kw := NewLocalKeyWrapper(mk)
+	env := NewEnvelope(kw)
+
+	for i, b := range [...][]byte{
+		nil, {}, {0},
+		randomBytes(make([]byte, 64)),
+		randomBytes(make([]byte, 1<<10)),
+	} {
+		s, err := env.Encrypt(copyBuffer(b))
+		if nil != err {
+			t.Fatalf("i=%d: %v", i, err)
+		}
+
+		r, err := env.Decrypt(nil, s)
+		if nil != err {
+			t.Fatalf("i=%d: %v", i, err)
+		}
+
+		if !bytes.Equal(r, b) {
+			exp := hex.EncodeToString(b)
+			act := hex.EncodeToString(r)
+			t.Errorf("i=%d: expected r = Hex(%q), actual Hex(%q)", i, exp, act)
+		}
+
+		// the token is a regular v2.local token under the unwrapped data key
+		_, a, err := decode(nil, s)
+		if nil != err {
+			t.Fatalf("i=%d: %v", i, err)
+		}
+
+		dk, err := kw.UnwrapKey(a.getFooter()[len(wrappedKeyPrefix):])
+		if nil != err {
+			t.Fatalf("i=%d: %v", i, err)
+		}
+
+		if r, _, err = rPASTDecrypt(dk, s); nil != err {
+			t.Fatalf("i=%d: %v", i, err)
+		}
+
+		if !bytes.Equal(r, b) {
+			exp := hex.EncodeToString(b)
+			act := hex.EncodeToString(r)
+			t.Errorf("i=%d: expected r = Hex(%q), actual Hex(%q)", i, exp, act)
+		}
+	}
+
+	badWrappedKey := func(t *testing.T) {
+		t.Parallel()
+
+		other := NewEnvelope(NewLocalKeyWrapper(
+			randomBytes(make([]byte, KeySize)),
+		))
+
+		s, err := other.Encrypt(randomBytes(make([]byte, 32)))
+		if nil != err {
+			t.Fatal(err)
+		}
+
+		for i, s := range [...]string{
+			s, // wrapped under a different master key
+			rPASTEncrypt(mk, randomBytes(make([]byte, 32)), ""),
+			rPASTEncrypt(mk, randomBytes(make([]byte, 32)), randomString(32)),
+		} {
+			if _, err := env.Decrypt(nil, s); err != ErrBadWrappedKey {
+				t.Errorf("i=%d: expected ErrBadWrappedKey, actual %v", i, err)
+			}
+		}
+	}
+
+	t.Run("badWrappedKey", badWrappedKey)
+}
+
+func TestEnvelopeNewBatch(t *testing.T) {
+	t.Parallel()
+
+	env := NewEnvelope(NewLocalKeyWrapper(randomBytes(make([]byte, KeySize))))
+	eng, err := env.NewBatch()
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 4; i++ {
+		b := randomBytes(make([]byte, 64))
+		r, err := env.Decrypt(nil, eng.Encrypt(copyBuffer(b)))
+		if nil != err {
+			t.Fatalf("i=%d: %v", i, err)
+		}
+
+		if !bytes.Equal(r, b) {
+			exp := hex.EncodeToString(b)
+			act := hex.EncodeToString(r)
+			t.Errorf("i=%d: expected r = Hex(%q), actual Hex(%q)", i, exp, act)
+		}
+	}
+}
+
+// prefixWrapper prepends prefix to the keys wrapped by KeyWrapper.
+type prefixWrapper struct {
+	KeyWrapper
+	prefix string
+}
+
+func (w prefixWrapper) WrapKey(dk []byte) ([]byte, error) {
+	wrapped, err := w.KeyWrapper.WrapKey(dk)
+	return append([]byte(w.prefix), wrapped...), err
+}
+
+func (w prefixWrapper) UnwrapKey(wrapped []byte) ([]byte, error) {
+	if !bytes.HasPrefix(wrapped, []byte(w.prefix)) {
+		return nil, ErrBadWrappedKey
+	}
+
+	return w.KeyWrapper.UnwrapKey(wrapped[len(w.prefix):])
+}
+
+func TestEnvelopeFooterFlags(t *testing.T) {
+	t.Parallel()
+
+	kw := NewLocalKeyWrapper(randomBytes(make([]byte, KeySize)))
+
+	// wrapped keys that look like flagged or reserved footers
+	for i, prefix := range [...]string{
+		"pad:", "deflate:", "\x00", flagMarker + "\x02",
+	} {
+		env := NewEnvelope(prefixWrapper{kw, prefix})
+		eng, err := env.NewBatch()
+		if nil != err {
+			t.Fatalf("i=%d: %v", i, err)
+		}
+
+		b := []byte("hello\x80")
+		for j, eng := range [...]Engine{eng, eng.WithPadding()} {
+			if r, err := env.Decrypt(nil, eng.Encrypt(copyBuffer(b))); nil != err {
+				t.Errorf("i=%d, j=%d: %v", i, j, err)
+			} else if !bytes.Equal(r, b) {
+				t.Errorf("i=%d, j=%d: expected %q, actual %q", i, j, b, r)
+			}
+		}
+	}
+}
+
+func TestLoadLocalKeyWrapper(t *testing.T) {
+	t.Parallel()
+
+	mk := randomBytes(make([]byte, KeySize))
+	dk := randomBytes(make([]byte, KeySize))
+	w, err := NewLocalKeyWrapper(mk).WrapKey(dk)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	for i, c := range [...]struct {
+		data []byte
+		err  error
+	}{
+		{mk, nil},
+		{[]byte(hex.EncodeToString(mk)), nil},
+		{[]byte(" " + hex.EncodeToString(mk) + "\n"), nil},
+		{mk[1:], ErrBadKeySize},
+		{[]byte(hex.EncodeToString(mk)[1:] + "x"), ErrBadEncoding},
+	} {
+		f, err := ioutil.TempFile("", "fpast2l-masterkey")
+		if nil != err {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+
+		if _, err = f.Write(c.data); nil != err {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		kw, err := LoadLocalKeyWrapper(f.Name())
+		if err != c.err {
+			t.Fatalf("i=%d: expected error %v, actual %v", i, c.err, err)
+		}
+
+		if nil != err {
+			continue
+		}
+
+		r, err := kw.UnwrapKey(w)
+		if nil != err {
+			t.Fatalf("i=%d: %v", i, err)
+		}
+
+		if !bytes.Equal(r, dk) {
+			t.Errorf("i=%d: unwrapped key does not match", i)
+		}
+	}
+}
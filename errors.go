@@ -13,9 +13,19 @@ const (
 // Errors.
 var (
 	ErrBadKeySize        = Error{errors.New("bad key size")}
+	ErrBadAEAD           = Error{errors.New("bad AEAD nonce or tag size")}
 	ErrBadHeader         = Error{errors.New("bad header")}
 	ErrBadEncoding       = Error{errors.New("bad encoding")}
 	ErrBadEncryption     = Error{errors.New("decryption failed")}
+	ErrBadCompression    = Error{errors.New("bad compressed payload")}
+	ErrPayloadTooLarge   = Error{errors.New("payload too large")}
+	ErrTokenTooLarge     = Error{errors.New("token too large")}
+	ErrBadPadding        = Error{errors.New("bad padding")}
+	ErrBadWrappedKey     = Error{errors.New("bad wrapped key")}
+	ErrBadKeyring        = Error{errors.New("bad keyring")}
+	ErrReservedFooter    = Error{errors.New("footer starts with a reserved NUL byte")}
+	ErrNoActiveKey       = Error{errors.New("no active key")}
+	ErrUnknownKey        = Error{errors.New("unknown or unusable key")}
 	ErrEngNotInitialized = Error{errors.New("eng not properly initialized")}
 )
 
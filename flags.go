@@ -0,0 +1,77 @@
+package fpast2l
+
+// Footer flags signal transformations
+// that were applied to the payload before encryption.
+// A flagged footer is flagMarker,
+// followed by a byte holding the flags and the footer;
+// footers without flags are left as they are.
+//
+// Footers starting with a NUL byte are reserved (see CheckFooter),
+// so footers given to an Engine are never taken for flagged ones.
+// Footers of other tokens are only taken for flagged ones
+// if they start with flagMarker and a valid flags byte.
+const (
+	flagDeflate = 1 << iota
+	flagPad
+
+	numFlags = iota
+)
+
+// flagMarker starts flagged footers:
+// the reserved NUL byte, a name and a format version.
+const flagMarker = "\x00fpast2l\x01"
+
+// CheckFooter returns ErrReservedFooter
+// if f starts with a NUL byte, which is reserved for footer flags
+// (see Engine.WithCompression and Engine.WithPadding).
+func CheckFooter(f string) error {
+	if 0 != len(f) && 0 == f[0] {
+		return ErrReservedFooter
+	}
+
+	return nil
+}
+
+// flaggedFooters returns f flagged with each combination of flags,
+// indexed by the flags.
+func flaggedFooters(f string) (ff [1 << numFlags]string) {
+	ff[0] = f
+	for flags := 1; flags < len(ff); flags++ {
+		ff[flags] = flagMarker + string(rune(flags)) + f
+	}
+
+	return
+}
+
+// splitFlags strips the flags from the footer f
+// and returns the remaining footer as r along with the flags.
+func splitFlags(f []byte) (r []byte, flags int) {
+	n := len(flagMarker)
+	if len(f) <= n || string(f[:n]) != flagMarker {
+		return f, 0
+	}
+
+	if flags = int(f[n]); 0 == flags || flags >= 1<<numFlags {
+		return f, 0
+	}
+
+	return f[n+1:], flags
+}
+
+// restore reverses the transformations flagged by flags
+// on the decrypted payload b.
+// Decompressed payloads of up to max bytes are appended to p.
+// (Also see inflate.)
+func restore(p, b []byte, flags, max int) (r []byte, err error) {
+	if 0 != flags&flagPad {
+		if b, err = unpad(b); nil != err {
+			return nil, err
+		}
+	}
+
+	if 0 != flags&flagDeflate {
+		return inflate(p, b, max)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,94 @@
+package fpast2l
+
+import (
+	"bytes"
+	"compress/flate"
+	"strings"
+	"testing"
+)
+
+func TestFooterFlags(t *testing.T) {
+	t.Parallel()
+
+	k := randomBytes(make([]byte, KeySize))
+
+	// footers that look like flags in other formats are just footers
+	for i, c := range [...]struct {
+		f string
+		b []byte
+	}{
+		{"deflate:kid", []byte("hello")},
+		{"pad:tenant-1", []byte("hello")},
+		{"pad:x", []byte("hello\x80")},
+		{"\x00fpast2l", []byte("hello")},                   // foreign, too short
+		{flagMarker[:len(flagMarker)-1] + "\x02\x01", nil}, // foreign, other version
+		{flagMarker + "\x00kid", []byte("hello\x80")},      // foreign, no flags
+		{flagMarker + "\xffkid", []byte("hello\x80")},      // foreign, unknown flags
+	} {
+		var s string
+		if 0 == len(c.f) || 0 != c.f[0] {
+			s = New(k).WithFooter(c.f).Encrypt(copyBuffer(c.b))
+		} else {
+			s = rPASTEncrypt(k, c.b, c.f)
+		}
+
+		r, err := New(k).Decrypt(nil, s)
+		if nil != err {
+			t.Errorf("i=%d: %v", i, err)
+		} else if !bytes.Equal(r, c.b) {
+			t.Errorf("i=%d: expected %q, actual %q", i, c.b, r)
+		}
+
+		if id, ok := KeyID(s); ok != (0 != len(c.f)) || id != c.f {
+			t.Errorf("i=%d: expected key ID %q, actual %q", i, c.f, id)
+		}
+	}
+
+	// flagged footers of an Engine are recognized
+	eng := New(k).WithFooter("pad:x").WithCompression(flate.BestCompression).WithPadding()
+	b := []byte(strings.Repeat("hello\x80", 16))
+	s := eng.Encrypt(copyBuffer(b))
+	if _, f, err := rPASTDecrypt(k, s); nil != err {
+		t.Fatal(err)
+	} else if exp := flagMarker + "\x03pad:x"; f != exp {
+		t.Errorf("expected f = %q, actual %q", exp, f)
+	}
+
+	if r, err := New(k).Decrypt(nil, s); nil != err || !bytes.Equal(r, b) {
+		t.Errorf("expected %q, actual %q, %v", b, r, err)
+	}
+
+	if id, _ := KeyID(s); "pad:x" != id {
+		t.Errorf("expected key ID %q, actual %q", "pad:x", id)
+	}
+
+	// key IDs that look like flags can be looked up
+	kr, err := ParseKeyring(strings.NewReader(`{"keys": [
+		{"id": "deflate:kid", "status": "active", "key": "` + FormatKey(k) + `"}]}`))
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	if s, err = kr.Encrypt(copyBuffer(b)); nil != err {
+		t.Fatal(err)
+	}
+
+	if r, err := kr.Decrypt(nil, s); nil != err || !bytes.Equal(r, b) {
+		t.Errorf("expected %q, actual %q, %v", b, r, err)
+	}
+
+	// reserved footers
+	if err := CheckFooter("\x00kid"); ErrReservedFooter != err {
+		t.Errorf("expected ErrReservedFooter, actual %v", err)
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); ErrReservedFooter != r {
+				t.Errorf("expected panic ErrReservedFooter, actual %v", r)
+			}
+		}()
+
+		New(k).WithFooter(flagMarker + "\x01kid")
+	}()
+}
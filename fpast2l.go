@@ -2,6 +2,7 @@ package fpast2l
 
 import (
 	"crypto/cipher"
+	"time"
 
 	"golang.org/x/crypto/chacha20poly1305"
 )
@@ -19,6 +20,20 @@ const KeySize = chacha20poly1305.KeySize
 type Engine struct {
 	ci cipher.AEAD
 	f  string
+	t  *templates
+
+	// compression, see WithCompression
+	zip    bool
+	zlevel int
+	zmax   int
+
+	// padding, see WithPadding
+	pad     bool
+	buckets []int
+
+	limits Limits   // see WithLimits
+	codec  b64Codec // see WithConstantTimeBase64
+	obs    Observer // see WithObserver
 }
 
 // New constructs and returns a new Engine,
@@ -29,37 +44,188 @@ func New(K []byte) (eng Engine) {
 		panic(ErrBadKeySize)
 	}
 
-	err := error(nil)
-	if eng.ci, err = chacha20poly1305.NewX(K); nil != err {
+	ci, err := chacha20poly1305.NewX(K)
+	if nil != err {
 		panic(err)
 	}
 
+	return NewWithAEAD(ci)
+}
+
+// NewWithAEAD constructs and returns a new Engine
+// that uses ci for encryption and decryption.
+//
+// ci must implement XChaCha20-Poly1305,
+// e.g. by delegating to a process that holds the key.
+// NewWithAEAD will panic if ci is nil
+// or if its nonce or tag size differ from those of XChaCha20-Poly1305.
+func NewWithAEAD(ci cipher.AEAD) (eng Engine) {
+	if nil == ci {
+		panic(ErrBadAEAD)
+	}
+
+	if ci.NonceSize() != nonceSize || ci.Overhead() != tagSize {
+		panic(ErrBadAEAD)
+	}
+
+	eng.ci, eng.t = ci, newTemplates("")
 	return
 }
 
+// templates holds the parts of the tokens of an Engine
+// that only depend on the footer,
+// indexed by footer flags (see flaggedFooters).
+// They are computed once per footer and never modified.
+type templates struct {
+	pae [1 << numFlags]string // PAE with a zero nonce (see paeTemplate)
+	f64 [1 << numFlags]string // base64 encoded footer
+}
+
+// newTemplates computes the templates for the footer f.
+func newTemplates(f string) *templates {
+	t := new(templates)
+	for flags, f := range flaggedFooters(f) {
+		t.pae[flags] = paeTemplate(f)
+		t.f64[flags] = b64.EncodeToString([]byte(f))
+	}
+
+	return t
+}
+
 // WithFooter returns a copy of Engine
 // with the footer in the copy set to f.
-func (eng Engine) WithFooter(f string) Engine { eng.f = f; return eng }
+// WithFooter will panic if f is reserved (see CheckFooter).
+func (eng Engine) WithFooter(f string) Engine {
+	if err := CheckFooter(f); nil != err {
+		panic(err)
+	}
+
+	eng.f, eng.t = f, newTemplates(f)
+	return eng
+}
+
+// WithCompression returns a copy of Engine
+// that DEFLATE-compresses payloads at the given compress/flate level
+// before encryption, if doing so makes them smaller.
+// Compressed tokens are flagged in the footer.
+//
+// Compression makes the length of tokens depend on the content
+// of their payloads, not just its length.
+// If an attacker controls part of a payload, e.g. some claims,
+// and observes token lengths,
+// it can learn the rest of the payload (as in CRIME).
+// Combine compression with WithPadding,
+// so tokens only reveal the bucket of the compressed length,
+// or do not compress payloads that mix secrets with attacker input.
+//
+// Decrypt decompresses flagged tokens
+// regardless of whether compression is enabled.
+// WithCompression will panic if level is invalid.
+func (eng Engine) WithCompression(level int) Engine {
+	checkCompressionLevel(level)
+	eng.zip, eng.zlevel = true, level
+	return eng
+}
+
+// WithMaxInflateSize returns a copy of Engine
+// that refuses to decompress payloads larger than n bytes.
+// If n is not positive, DefaultMaxInflateSize is used.
+func (eng Engine) WithMaxInflateSize(n int) Engine { eng.zmax = n; return eng }
+
+// WithPadding returns a copy of Engine
+// that pads payloads before encryption
+// to hide their exact length.
+// Payloads are padded to the smallest of buckets that fits them
+// or to multiples of the largest bucket.
+// If no buckets are given, payloads are padded to powers of two.
+// Padded tokens are flagged in the footer.
+//
+// Padding is applied after compression (see WithCompression).
+// Decrypt strips padding from flagged tokens
+// regardless of whether padding is enabled.
+// WithPadding will panic
+// unless buckets are positive and strictly ascending.
+func (eng Engine) WithPadding(buckets ...int) Engine {
+	checkPaddingBuckets(buckets)
+	eng.pad, eng.buckets = true, append([]int(nil), buckets...)
+	return eng
+}
+
+// maxInflateSize returns the decompressed payload size limit of eng
+// (see WithMaxInflateSize and WithLimits).
+func (eng Engine) maxInflateSize() int {
+	if eng.zmax <= 0 {
+		return eng.limits.maxInflateSize(DefaultMaxInflateSize)
+	}
+
+	return eng.limits.maxInflateSize(eng.zmax)
+}
 
 // Encrypt creates and returns a new PASETO v2 local token
 // from the payload contained in b.
 // b is encrypted in-place,
 // meaning the contents of b will be overwritten with raw ciphertext.
 // It is safe to reuse b or throw it away.
+//
+// If compression or padding is enabled
+// (see WithCompression and WithPadding),
+// b is transformed in-place before encryption.
 func (eng Engine) Encrypt(b []byte) string {
 	if nil == eng.ci {
 		panic(ErrEngNotInitialized)
 	}
 
-	_, p := extend(b, tagSize+minPAESize+len(eng.f))
-	p = p[len(b):]
+	if nil == eng.obs {
+		return eng.encryptToken(b)
+	}
+
+	t, n := time.Now(), len(b)
+	s := eng.encryptToken(b)
+	eng.obs.Observe(Observation{
+		Op:        OpEncrypt,
+		Size:      n,
+		TokenSize: len(s),
+		Duration:  time.Since(t),
+	})
+
+	return s
+}
+
+// encryptToken implements Encrypt.
+func (eng Engine) encryptToken(b []byte) string {
+	c, a, flags := eng.seal(b)
+	return encodeFooter(c, a, eng.t.f64[flags], eng.codec)
+}
+
+// seal transforms (see WithCompression and WithPadding)
+// and encrypts b in-place
+// and returns the ciphertext c
+// along with the pre-authentication encoding a
+// and the footer flags.
+func (eng Engine) seal(b []byte) (c []byte, a pae, flags int) {
+	if eng.zip {
+		if r, ok := deflate(b, eng.zlevel); ok {
+			b, flags = r, flags|flagDeflate
+		}
+	}
 
-	a := pae(p)
-	a.init(len(eng.f))
+	if eng.pad {
+		flags |= flagPad
+	}
+
+	t := eng.t.pae[flags]
+	if eng.pad {
+		b = pad(b, paddedSize(len(b)+1, eng.buckets), tagSize+len(t))
+	}
+
+	_, p := extend(b, tagSize+len(t))
+	p = p[len(b):][:len(t)]
+	copy(p, t)
+
+	a = pae(p)
 	a.generateNonce(b)
-	a.setFooter(eng.f)
 
-	return encode(encrypt(eng.ci, b, a), a)
+	return encrypt(eng.ci, b, a), a, flags
 }
 
 // Decrypt parses and decrypt s as a PASETO v2 local token.
@@ -67,10 +233,33 @@ func (eng Engine) Encrypt(b []byte) string {
 //
 // Extra capacity of p,
 // if available, is used for computation.
+// Padding is stripped and compressed payloads are decompressed,
+// up to the limit set by WithMaxInflateSize.
+// Tokens exceeding the limits set by WithLimits
+// are rejected before anything is allocated.
 // Even if the encryption is unsuccessful, p should be
 // overwritten or thrown away.
 func (eng Engine) Decrypt(p []byte, s string) (b []byte, err error) {
-	b, a, err := decode(p, s)
+	if nil == eng.obs {
+		return eng.decryptToken(p, s)
+	}
+
+	t := time.Now()
+	b, err = eng.decryptToken(p, s)
+	eng.obs.Observe(Observation{
+		Op:        OpDecrypt,
+		Err:       err,
+		Size:      len(b),
+		TokenSize: len(s),
+		Duration:  time.Since(t),
+	})
+
+	return
+}
+
+// decryptToken implements Decrypt.
+func (eng Engine) decryptToken(p []byte, s string) (b []byte, err error) {
+	b, a, err := decodeLimited(p, s, eng.limits, eng.codec)
 	if nil != err {
 		return nil, err
 	}
@@ -80,6 +269,10 @@ func (eng Engine) Decrypt(p []byte, s string) (b []byte, err error) {
 		return nil, err
 	}
 
+	if _, flags := splitFlags(a.getFooter()); 0 != flags {
+		return restore(p, b, flags, eng.maxInflateSize())
+	}
+
 	return
 }
 
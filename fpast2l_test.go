@@ -2,6 +2,7 @@ package fpast2l
 
 import (
 	"bytes"
+	"crypto/cipher"
 	"encoding/base32"
 	"encoding/hex"
 	"math/rand"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"github.com/o1egl/paseto"
+	"golang.org/x/crypto/chacha20poly1305"
 )
 
 var (
@@ -80,6 +82,40 @@ func TestEngineDecrypt(t *testing.T) {
 	}
 }
 
+func TestNewWithAEAD(t *testing.T) {
+	t.Parallel()
+
+	for i, ci := range [...]cipher.AEAD{
+		nil,
+		mustAEAD(chacha20poly1305.New(randomBytes(make([]byte, KeySize)))),
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r != ErrBadAEAD {
+					t.Errorf("i=%d: expected panic(ErrBadAEAD), actual %v", i, r)
+				}
+			}()
+
+			NewWithAEAD(ci)
+		}()
+	}
+
+	k := randomBytes(make([]byte, KeySize))
+	eng := NewWithAEAD(mustAEAD(chacha20poly1305.NewX(k)))
+	b := randomBytes(make([]byte, 64))
+
+	r, _, err := rPASTDecrypt(k, eng.Encrypt(copyBuffer(b)))
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(r, b) {
+		exp := hex.EncodeToString(b)
+		act := hex.EncodeToString(r)
+		t.Errorf("expected r = Hex(%q), actual Hex(%q)", exp, act)
+	}
+}
+
 func BenchmarkEngineEncrypt(b *testing.B) {
 	eng := New(randomBytes(make([]byte, KeySize)))
 	B := randomBytes(make([]byte, 32, 128))
@@ -102,6 +138,27 @@ func BenchmarkEngineEncrypt(b *testing.B) {
 	})
 }
 
+func BenchmarkEngineEncryptTemplate(b *testing.B) {
+	eng := New(randomBytes(make([]byte, KeySize))).WithFooter(randomString(32))
+	B := randomBytes(make([]byte, 8, 128))
+
+	b.Run("Template", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			_ = eng.Encrypt(B)
+		}
+	})
+
+	b.Run("NoTemplate", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			_ = encryptNoTemplate(eng, B)
+		}
+	})
+}
+
 func BenchmarkEngineDecrypt(b *testing.B) {
 	k := randomBytes(make([]byte, KeySize))
 	eng := New(k)
@@ -128,6 +185,20 @@ func BenchmarkEngineDecrypt(b *testing.B) {
 	})
 }
 
+// encryptNoTemplate is Engine.Encrypt
+// assembling the PAE and footer from scratch on every call.
+func encryptNoTemplate(eng Engine, b []byte) string {
+	_, p := extend(b, tagSize+minPAESize+len(eng.f))
+	p = p[len(b):]
+
+	a := pae(p)
+	a.init(len(eng.f))
+	a.generateNonce(b)
+	a.setFooter(eng.f)
+
+	return encode(encrypt(eng.ci, b, a), a)
+}
+
 func randomBytes(p []byte) (b []byte) {
 	if _, err := rand.Read(p); nil != err {
 		panic(err)
@@ -148,6 +219,14 @@ func copyBuffer(p []byte) (b []byte) {
 	return
 }
 
+func mustAEAD(ci cipher.AEAD, err error) cipher.AEAD {
+	if nil != err {
+		panic(err)
+	}
+
+	return ci
+}
+
 func rPASTEncrypt(key, b []byte, f string) (s string) {
 	_f := (interface{})(f)
 	if 0 == len(f) {
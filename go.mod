@@ -1,15 +1,21 @@
 module github.com/zrhmn/fpast2l
 
-go 1.13
+go 1.24
 
 require (
 	github.com/aead/poly1305 v0.0.0-20180717145839-3fee0db0b635
-	github.com/kr/pretty v0.1.0 // indirect
 	github.com/o1egl/paseto v1.0.0
 	github.com/rs/zerolog v1.17.2
-	github.com/stretchr/testify v1.4.0 // indirect
 	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550
-	golang.org/x/sys v0.0.0-20190826190057-c7b8b68b1456 // indirect
+	golang.org/x/sys v0.0.0-20190826190057-c7b8b68b1456
+	gopkg.in/yaml.v2 v2.2.4
+)
+
+require (
+	github.com/aead/chacha20 v0.0.0-20180709150244-8b13a72661da // indirect
+	github.com/aead/chacha20poly1305 v0.0.0-20170617001512-233f39982aeb // indirect
+	github.com/kr/pretty v0.1.0 // indirect
+	github.com/pkg/errors v0.8.1 // indirect
+	github.com/stretchr/testify v1.4.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
-	gopkg.in/yaml.v2 v2.2.4 // indirect
 )
@@ -0,0 +1,524 @@
+package fpast2l
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// paserkLocal is the PASERK type prefix for v2 local (symmetric) keys.
+const paserkLocal = "k2.local."
+
+// KeyStatus describes what a Key in a Keyring may be used for.
+type KeyStatus int
+
+// Key statuses.
+const (
+	// KeyActive keys are used to encrypt and decrypt tokens.
+	KeyActive KeyStatus = iota + 1
+	// KeyVerifyOnly keys are only used to decrypt tokens.
+	KeyVerifyOnly
+	// KeyRetired keys are not used at all.
+	KeyRetired
+)
+
+var keyStatusNames = [...]string{
+	KeyActive:     "active",
+	KeyVerifyOnly: "verify-only",
+	KeyRetired:    "retired",
+}
+
+// String implements fmt.Stringer.
+func (s KeyStatus) String() string {
+	if s < KeyActive || s > KeyRetired {
+		return "invalid"
+	}
+
+	return keyStatusNames[s]
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s KeyStatus) MarshalText() ([]byte, error) {
+	if s < KeyActive || s > KeyRetired {
+		return nil, ErrBadKeyring
+	}
+
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *KeyStatus) UnmarshalText(b []byte) error {
+	for i, name := range keyStatusNames {
+		if 0 != i && name == string(b) {
+			*s = KeyStatus(i)
+			return nil
+		}
+	}
+
+	return ErrBadKeyring
+}
+
+// Key is a single entry of a Keyring.
+//
+// The Engine of a Key has the key ID set as its footer,
+// which is how Keyring.Decrypt finds the key for a token.
+type Key struct {
+	ID        string
+	Status    KeyStatus
+	NotBefore time.Time
+	NotAfter  time.Time
+	Engine    Engine
+}
+
+// ValidAt returns whether the validity window of k contains t.
+// A zero NotBefore or NotAfter leaves that side of the window open.
+func (k Key) ValidAt(t time.Time) bool {
+	if !k.NotBefore.IsZero() && t.Before(k.NotBefore) {
+		return false
+	}
+
+	if !k.NotAfter.IsZero() && !t.Before(k.NotAfter) {
+		return false
+	}
+
+	return true
+}
+
+// Keyring is an immutable set of keys
+// loaded from a keyring file.
+//
+// A keyring file is a JSON document of the form
+//
+//	{"keys": [{
+//		"id": "2019-11",
+//		"status": "active",
+//		"key": "k2.local.<base64url>",
+//		"notBefore": "2019-11-01T00:00:00Z",
+//		"notAfter": "2020-01-01T00:00:00Z"
+//	}]}
+//
+// where status is one of active, verify-only and retired,
+// key is either PASERK (k2.local.) or hex encoded
+// and notBefore and notAfter are optional RFC 3339 timestamps.
+type Keyring struct {
+	keys   []Key
+	limits Limits
+	codec  b64Codec
+}
+
+// keyringFile is the on-disk representation of a Keyring.
+type keyringFile struct {
+	Keys []struct {
+		ID        string     `json:"id"`
+		Status    KeyStatus  `json:"status"`
+		Key       string     `json:"key"`
+		NotBefore *time.Time `json:"notBefore"`
+		NotAfter  *time.Time `json:"notAfter"`
+	} `json:"keys"`
+}
+
+// ParseKeyring reads a keyring file from r
+// and returns the resulting Keyring.
+// Unknown fields, duplicate, empty or reserved key IDs (see CheckFooter)
+// and malformed keys are errors.
+func ParseKeyring(r io.Reader) (*Keyring, error) {
+	var kf keyringFile
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&kf); nil != err {
+		return nil, ErrBadKeyring
+	}
+
+	kr := &Keyring{keys: make([]Key, 0, len(kf.Keys))}
+	for _, e := range kf.Keys {
+		if 0 == len(e.ID) || nil != CheckFooter(e.ID) || 0 == e.Status {
+			return nil, ErrBadKeyring
+		}
+
+		if _, ok := kr.Lookup(e.ID); ok {
+			return nil, ErrBadKeyring
+		}
+
+		K, err := ParseKey(e.Key)
+		if nil != err {
+			return nil, err
+		}
+
+		k := Key{ID: e.ID, Status: e.Status}
+		if nil != e.NotBefore {
+			k.NotBefore = *e.NotBefore
+		}
+
+		if nil != e.NotAfter {
+			k.NotAfter = *e.NotAfter
+		}
+
+		if !k.NotBefore.IsZero() && !k.NotAfter.IsZero() &&
+			!k.NotBefore.Before(k.NotAfter) {
+			return nil, ErrBadKeyring
+		}
+
+		k.Engine = New(K).WithFooter(k.ID)
+		zero(K)
+
+		kr.keys = append(kr.keys, k)
+	}
+
+	return kr, nil
+}
+
+// LoadKeyring reads and parses the keyring file at path.
+func LoadKeyring(path string) (*Keyring, error) {
+	f, err := os.Open(path)
+	if nil != err {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseKeyring(f)
+}
+
+// ParseKey decodes s as a PASERK v2 local key (k2.local.)
+// or as the hex encoding of KeySize bytes.
+func ParseKey(s string) ([]byte, error) {
+	b := []byte(s)
+	defer zero(b)
+	return ParseKeyBytes(b)
+}
+
+// ParseKeyBytes is like ParseKey,
+// but decodes b without copying it,
+// so that the caller can zero b afterwards.
+func ParseKeyBytes(b []byte) ([]byte, error) {
+	var (
+		K   []byte
+		err error
+	)
+
+	if bytes.HasPrefix(b, []byte(paserkLocal)) {
+		b = b[len(paserkLocal):]
+		K = make([]byte, b64.DecodedLen(len(b)))
+		_, err = b64.Decode(K, b)
+	} else {
+		K = make([]byte, hex.DecodedLen(len(b)))
+		_, err = hex.Decode(K, b)
+	}
+
+	if nil != err {
+		zero(K)
+		return nil, ErrBadEncoding
+	}
+
+	if len(K) != KeySize {
+		zero(K)
+		return nil, ErrBadKeySize
+	}
+
+	return K, nil
+}
+
+// FormatKey returns the PASERK (k2.local.) encoding of the key K.
+// FormatKey will panic if len(K) is not exactly KeySize bytes.
+func FormatKey(K []byte) string {
+	if len(K) != KeySize {
+		panic(ErrBadKeySize)
+	}
+
+	return paserkLocal + b64.EncodeToString(K)
+}
+
+// Keys returns a copy of the keys in kr.
+func (kr *Keyring) Keys() []Key {
+	return append([]Key(nil), kr.keys...)
+}
+
+// Lookup returns the key with the given ID.
+func (kr *Keyring) Lookup(id string) (Key, bool) {
+	for _, k := range kr.keys {
+		if k.ID == id {
+			return k, true
+		}
+	}
+
+	return Key{}, false
+}
+
+// Active returns the active key used for encryption at time t.
+// If more than one active key is valid at t,
+// the one with the latest NotBefore wins.
+func (kr *Keyring) Active(t time.Time) (Key, bool) {
+	i := -1
+	for j, k := range kr.keys {
+		if KeyActive != k.Status || !k.ValidAt(t) {
+			continue
+		}
+
+		if -1 == i || k.NotBefore.After(kr.keys[i].NotBefore) {
+			i = j
+		}
+	}
+
+	if -1 == i {
+		return Key{}, false
+	}
+
+	return kr.keys[i], true
+}
+
+// Encrypt encrypts b with the currently active key.
+// (Also see Engine.Encrypt.)
+func (kr *Keyring) Encrypt(b []byte) (string, error) {
+	k, ok := kr.Active(time.Now())
+	if !ok {
+		return "", ErrNoActiveKey
+	}
+
+	return k.Engine.Encrypt(b), nil
+}
+
+// Decrypt decrypts s with the key whose ID is the footer of s
+// (ignoring footer flags, see Engine.WithCompression).
+// The key must be active or verify-only
+// and valid at the current time.
+// (Also see Engine.Decrypt.)
+func (kr *Keyring) Decrypt(p []byte, s string) ([]byte, error) {
+	b, a, err := decodeLimited(p, s, kr.limits, kr.codec)
+	if nil != err {
+		return nil, err
+	}
+
+	f, flags := splitFlags(a.getFooter())
+	k, ok := kr.lookupFooter(f)
+	if !ok || KeyRetired == k.Status || !k.ValidAt(time.Now()) {
+		return nil, ErrUnknownKey
+	}
+
+	if b, err = decrypt(k.Engine.ci, b, a); nil != err || 0 == flags {
+		return b, err
+	}
+
+	return restore(p, b, flags, k.Engine.maxInflateSize())
+}
+
+// WithLimits returns a copy of kr
+// whose Decrypt rejects tokens exceeding l
+// (see Engine.WithLimits).
+func (kr *Keyring) WithLimits(l Limits) *Keyring {
+	r := &Keyring{keys: kr.Keys(), limits: l, codec: kr.codec}
+	for i := range r.keys {
+		r.keys[i].Engine = r.keys[i].Engine.WithLimits(l)
+	}
+
+	return r
+}
+
+// WithConstantTimeBase64 returns a copy of kr
+// whose Encrypt and Decrypt encode and decode tokens
+// in constant time
+// (see Engine.WithConstantTimeBase64).
+func (kr *Keyring) WithConstantTimeBase64() *Keyring {
+	r := &Keyring{keys: kr.Keys(), limits: kr.limits, codec: ctB64}
+	for i := range r.keys {
+		r.keys[i].Engine = r.keys[i].Engine.WithConstantTimeBase64()
+	}
+
+	return r
+}
+
+// KeyID returns the ID of the key token s names,
+// its footer without flags, as used by Keyring.Decrypt.
+// s is not decrypted, so the ID is not authenticated
+// unless s has been decrypted successfully.
+// ok is false if s is not a v2 local token with a footer.
+func KeyID(s string) (id string, ok bool) {
+	if len(s) < headerSize || s[:headerSize] != header {
+		return "", false
+	}
+
+	i := strings.IndexByte(s[headerSize:], '.')
+	if i < 0 {
+		return "", false
+	}
+
+	f, err := b64.DecodeString(s[headerSize+i+1:])
+	if nil != err || 0 == len(f) {
+		return "", false
+	}
+
+	f, _ = splitFlags(f)
+	return string(f), true
+}
+
+// lookupFooter is Lookup without converting f to a string.
+func (kr *Keyring) lookupFooter(f []byte) (Key, bool) {
+	for _, k := range kr.keys {
+		if len(k.ID) == len(f) && k.ID == string(f) {
+			return k, true
+		}
+	}
+
+	return Key{}, false
+}
+
+// KeyringWatcher holds the Keyring loaded from a file
+// and reloads it when the file changes.
+//
+// Reloads swap the Keyring atomically:
+// calls in flight keep using the Keyring they started with.
+// KeyringWatcher is safe for concurrent use.
+type KeyringWatcher struct {
+	path  string
+	onErr func(error)
+	kr    atomic.Value // *Keyring
+
+	mu     sync.Mutex // guards stat, limits and codec, serializes reloads
+	stat   os.FileInfo
+	limits Limits
+	codec  b64Codec
+	close  chan struct{}
+	once   sync.Once
+}
+
+// WatchKeyring loads the keyring file at path
+// and polls it for changes every interval.
+// If a reload fails, onErr (if non-nil) is called with the error
+// and the previously loaded Keyring stays in use.
+//
+// Close must be called to stop watching.
+func WatchKeyring(
+	path string, interval time.Duration, onErr func(error),
+) (*KeyringWatcher, error) {
+	w := &KeyringWatcher{
+		path:  path,
+		onErr: onErr,
+		close: make(chan struct{}),
+	}
+
+	if err := w.Reload(); nil != err {
+		return nil, err
+	}
+
+	if interval > 0 {
+		go w.watch(interval)
+	}
+
+	return w, nil
+}
+
+// Keyring returns the currently loaded Keyring.
+func (w *KeyringWatcher) Keyring() *Keyring {
+	return w.kr.Load().(*Keyring)
+}
+
+// Encrypt is Keyring.Encrypt on the currently loaded Keyring.
+func (w *KeyringWatcher) Encrypt(b []byte) (string, error) {
+	return w.Keyring().Encrypt(b)
+}
+
+// Decrypt is Keyring.Decrypt on the currently loaded Keyring.
+func (w *KeyringWatcher) Decrypt(p []byte, s string) ([]byte, error) {
+	return w.Keyring().Decrypt(p, s)
+}
+
+// SetLimits sets the limits (see Keyring.WithLimits)
+// of the currently loaded Keyring
+// and of those loaded from now on.
+func (w *KeyringWatcher) SetLimits(l Limits) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.limits = l
+	w.kr.Store(w.Keyring().WithLimits(l))
+}
+
+// SetConstantTimeBase64 makes the currently loaded Keyring
+// and those loaded from now on
+// encode and decode tokens in constant time
+// (see Keyring.WithConstantTimeBase64).
+func (w *KeyringWatcher) SetConstantTimeBase64() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.codec = ctB64
+	w.kr.Store(w.Keyring().WithConstantTimeBase64())
+}
+
+// Reload loads the keyring file
+// and swaps it in if it parses successfully.
+func (w *KeyringWatcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.reload()
+}
+
+// Close stops watching the keyring file.
+func (w *KeyringWatcher) Close() {
+	w.once.Do(func() { close(w.close) })
+}
+
+func (w *KeyringWatcher) reload() error {
+	fi, err := os.Stat(w.path)
+	if nil != err {
+		return err
+	}
+
+	// remember the file even if it fails to parse,
+	// so a bad file is only reported once
+	w.stat = fi
+
+	kr, err := LoadKeyring(w.path)
+	if nil != err {
+		return err
+	}
+
+	if kr = kr.WithLimits(w.limits); ctB64 == w.codec {
+		kr = kr.WithConstantTimeBase64()
+	}
+
+	w.kr.Store(kr)
+	return nil
+}
+
+// changed reports whether the keyring file
+// differs from when it was last loaded.
+func (w *KeyringWatcher) changed() bool {
+	fi, err := os.Stat(w.path)
+	if nil != err {
+		return false // keep the current keyring, report on next change
+	}
+
+	return !fi.ModTime().Equal(w.stat.ModTime()) ||
+		fi.Size() != w.stat.Size() ||
+		!os.SameFile(fi, w.stat)
+}
+
+func (w *KeyringWatcher) watch(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-w.close:
+			return
+		case <-t.C:
+		}
+
+		w.mu.Lock()
+		err := error(nil)
+		if w.changed() {
+			err = w.reload()
+		}
+		w.mu.Unlock()
+
+		if nil != err && nil != w.onErr {
+			w.onErr(err)
+		}
+	}
+}
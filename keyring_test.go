@@ -0,0 +1,269 @@
+package fpast2l
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseKeyring(t *testing.T) {
+	t.Parallel()
+
+	k0 := randomBytes(make([]byte, KeySize))
+	k1 := randomBytes(make([]byte, KeySize))
+	k2 := randomBytes(make([]byte, KeySize))
+
+	kr, err := ParseKeyring(strings.NewReader(fmt.Sprintf(`{"keys": [
+		{"id": "k0", "status": "retired", "key": %q},
+		{"id": "k1", "status": "verify-only", "key": %q,
+			"notAfter": "2999-01-01T00:00:00Z"},
+		{"id": "k2", "status": "active", "key": %q,
+			"notBefore": "2000-01-01T00:00:00Z"}
+	]}`, hex.EncodeToString(k0), FormatKey(k1), FormatKey(k2))))
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	for i, c := range [...]struct {
+		id     string
+		status KeyStatus
+	}{
+		{"k0", KeyRetired},
+		{"k1", KeyVerifyOnly},
+		{"k2", KeyActive},
+	} {
+		k, ok := kr.Lookup(c.id)
+		if !ok {
+			t.Fatalf("i=%d: key %q not found", i, c.id)
+		}
+
+		if k.Status != c.status {
+			t.Errorf("i=%d: expected status %v, actual %v", i, c.status, k.Status)
+		}
+	}
+
+	if k, ok := kr.Active(time.Now()); !ok || k.ID != "k2" {
+		t.Errorf("expected active key k2, actual %q", k.ID)
+	}
+
+	if _, ok := kr.Active(time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC)); ok {
+		t.Errorf("expected no active key before k2.NotBefore")
+	}
+
+	b := randomBytes(make([]byte, 64))
+	s, err := kr.Encrypt(copyBuffer(b))
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	if r, f, err := rPASTDecrypt(k2, s); nil != err {
+		t.Fatal(err)
+	} else if !bytes.Equal(r, b) || f != "k2" {
+		t.Errorf("expected r = Hex(%q), f = %q, actual Hex(%q), %q",
+			hex.EncodeToString(b), "k2", hex.EncodeToString(r), f)
+	}
+
+	for i, c := range [...]struct {
+		s   string
+		err error
+	}{
+		{s, nil},
+		{rPASTEncrypt(k1, b, "k1"), nil},
+		{rPASTEncrypt(k0, b, "k0"), ErrUnknownKey},
+		{rPASTEncrypt(k2, b, ""), ErrUnknownKey},
+		{rPASTEncrypt(k2, b, "k3"), ErrUnknownKey},
+		{rPASTEncrypt(k2, b, "k1"), ErrBadEncryption},
+	} {
+		r, err := kr.Decrypt(nil, c.s)
+		if err != c.err {
+			t.Fatalf("i=%d: expected error %v, actual %v", i, c.err, err)
+		}
+
+		if nil == err && !bytes.Equal(r, b) {
+			exp := hex.EncodeToString(b)
+			act := hex.EncodeToString(r)
+			t.Errorf("i=%d: expected r = Hex(%q), actual Hex(%q)", i, exp, act)
+		}
+	}
+
+	badKeyring := func(t *testing.T) {
+		t.Parallel()
+
+		key := FormatKey(k0)
+		for i, s := range [...]string{
+			``,
+			`[]`,
+			`{"keys": [{"id": "", "status": "active", "key": "` + key + `"}]}`,
+			`{"keys": [{"id": "\u0000a", "status": "active", "key": "` + key + `"}]}`,
+			`{"keys": [{"id": "a", "status": "", "key": "` + key + `"}]}`,
+			`{"keys": [{"id": "a", "status": "bogus", "key": "` + key + `"}]}`,
+			`{"keys": [{"id": "a", "key": "` + key + `"}]}`,
+			`{"keys": [{"id": "a", "status": "active", "key": "` + key + `",
+				"extra": true}]}`,
+			`{"keys": [{"id": "a", "status": "active", "key": "` + key + `"},
+				{"id": "a", "status": "retired", "key": "` + key + `"}]}`,
+			`{"keys": [{"id": "a", "status": "active", "key": "` + key + `",
+				"notBefore": "2020-01-01T00:00:00Z",
+				"notAfter": "2019-01-01T00:00:00Z"}]}`,
+		} {
+			if _, err := ParseKeyring(strings.NewReader(s)); err != ErrBadKeyring {
+				t.Errorf("i=%d: expected ErrBadKeyring, actual %v", i, err)
+			}
+		}
+
+		for i, s := range [...]string{
+			`{"keys": [{"id": "a", "status": "active", "key": "k2.local.!"}]}`,
+			`{"keys": [{"id": "a", "status": "active", "key": "xyz"}]}`,
+		} {
+			if _, err := ParseKeyring(strings.NewReader(s)); err != ErrBadEncoding {
+				t.Errorf("i=%d: expected ErrBadEncoding, actual %v", i, err)
+			}
+		}
+
+		s := `{"keys": [{"id": "a", "status": "active", "key": "00"}]}`
+		if _, err := ParseKeyring(strings.NewReader(s)); err != ErrBadKeySize {
+			t.Errorf("expected ErrBadKeySize, actual %v", err)
+		}
+	}
+
+	t.Run("badKeyring", badKeyring)
+}
+
+func TestParseKey(t *testing.T) {
+	t.Parallel()
+
+	k := randomBytes(make([]byte, KeySize))
+	for i, c := range [...]struct {
+		s   string
+		err error
+	}{
+		{hex.EncodeToString(k), nil},
+		{strings.ToUpper(hex.EncodeToString(k)), nil},
+		{FormatKey(k), nil},
+		{"k2.local.!", ErrBadEncoding},
+		{"xyz", ErrBadEncoding},
+		{"00", ErrBadKeySize},
+		{FormatKey(k)[:20], ErrBadKeySize},
+	} {
+		K, err := ParseKey(c.s)
+		if err != c.err || (nil == err) != bytes.Equal(K, k) {
+			t.Errorf("i=%d: expected err = %v, actual %v (%x)", i, c.err, err, K)
+		}
+
+		b := []byte(c.s)
+		if K, err = ParseKeyBytes(b); err != c.err || (nil == err) != bytes.Equal(K, k) || c.s != string(b) {
+			t.Errorf("i=%d: ParseKeyBytes: expected err = %v, actual %v (%x)", i, c.err, err, K)
+		}
+	}
+}
+
+func TestWatchKeyring(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "fpast2l-keyring")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "keyring.json")
+	write := func(s string) {
+		tmp := path + ".tmp"
+		if err := ioutil.WriteFile(tmp, []byte(s), 0600); nil != err {
+			t.Fatal(err)
+		}
+
+		if err := os.Rename(tmp, path); nil != err {
+			t.Fatal(err)
+		}
+	}
+
+	k0 := FormatKey(randomBytes(make([]byte, KeySize)))
+	k1 := FormatKey(randomBytes(make([]byte, KeySize)))
+	write(`{"keys": [{"id": "k0", "status": "active", "key": "` + k0 + `"}]}`)
+
+	errs := make(chan error, 1)
+	w, err := WatchKeyring(path, time.Millisecond, func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	s0, err := w.Encrypt(randomBytes(make([]byte, 32)))
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	write(`{"keys": [
+		{"id": "k0", "status": "verify-only", "key": "` + k0 + `"},
+		{"id": "k1", "status": "active", "key": "` + k1 + `"}
+	]}`)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if k, ok := w.Keyring().Active(time.Now()); ok && "k1" == k.ID {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("keyring was not reloaded")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := w.Decrypt(nil, s0); nil != err {
+		t.Errorf("expected token from k0 to verify, actual %v", err)
+	}
+
+	kr := w.Keyring()
+	write(`{"keys": [`) // broken file must not replace the keyring
+
+	select {
+	case err := <-errs:
+		if err != ErrBadKeyring {
+			t.Errorf("expected ErrBadKeyring, actual %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reload error was not reported")
+	}
+
+	if w.Keyring() != kr {
+		t.Errorf("expected keyring to be retained after failed reload")
+	}
+}
+
+func TestKeyID(t *testing.T) {
+	t.Parallel()
+
+	k := randomBytes(make([]byte, KeySize))
+	p := permissionsPayload(64)
+	for i, c := range [...]struct {
+		s  string
+		id string
+		ok bool
+	}{
+		{New(k).Encrypt(copyBuffer(p)), "", false},
+		{New(k).WithFooter("2019-11").Encrypt(copyBuffer(p)), "2019-11", true},
+		{New(k).WithFooter("k1").WithCompression(1).WithPadding().Encrypt(copyBuffer(p)), "k1", true},
+		{"v2.local.AAAA.a2V5", "key", true},
+		{"v2.local.AAAA.!!!!", "", false},
+		{"v1.local.AAAA.a2V5", "", false},
+		{"v2.local.", "", false},
+	} {
+		if id, ok := KeyID(c.s); id != c.id || ok != c.ok {
+			t.Errorf("i=%d: expected (%q, %v), actual (%q, %v)", i, c.id, c.ok, id, ok)
+		}
+	}
+}
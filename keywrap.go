@@ -0,0 +1,102 @@
+package fpast2l
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// keyWrapAD is the additional data
+// used by LocalKeyWrapper to bind wrapped keys to their purpose.
+const keyWrapAD = "fpast2l.keywrap.v1"
+
+// LocalKeyWrapper is a KeyWrapper
+// that wraps data keys with XChaCha20-Poly1305
+// under a master key held in process memory.
+//
+// A wrapped key is the random nonce followed by the sealed data key.
+type LocalKeyWrapper struct {
+	ci cipher.AEAD
+}
+
+// NewLocalKeyWrapper constructs and returns a new LocalKeyWrapper
+// with the master key K.
+// NewLocalKeyWrapper will panic if len(K) is not exactly KeySize bytes.
+func NewLocalKeyWrapper(K []byte) LocalKeyWrapper {
+	if len(K) != KeySize {
+		panic(ErrBadKeySize)
+	}
+
+	ci, err := chacha20poly1305.NewX(K)
+	if nil != err {
+		panic(err)
+	}
+
+	return LocalKeyWrapper{ci}
+}
+
+// LoadLocalKeyWrapper reads the master key from the file at path
+// and returns a LocalKeyWrapper using it.
+//
+// The file must contain exactly KeySize raw bytes
+// or the hex encoding of KeySize bytes,
+// optionally surrounded by whitespace.
+func LoadLocalKeyWrapper(path string) (LocalKeyWrapper, error) {
+	b, err := ioutil.ReadFile(path)
+	if nil != err {
+		return LocalKeyWrapper{}, err
+	}
+	defer zero(b)
+
+	if len(b) != KeySize {
+		h := bytes.TrimSpace(b)
+		if hex.DecodedLen(len(h)) != KeySize {
+			return LocalKeyWrapper{}, ErrBadKeySize
+		}
+
+		if _, err = hex.Decode(b, h); nil != err {
+			return LocalKeyWrapper{}, ErrBadEncoding
+		}
+
+		b = b[:KeySize]
+	}
+
+	return NewLocalKeyWrapper(b), nil
+}
+
+// WrapKey implements KeyWrapper.
+func (w LocalKeyWrapper) WrapKey(dk []byte) ([]byte, error) {
+	if nil == w.ci {
+		panic(ErrEngNotInitialized)
+	}
+
+	b := make([]byte, nonceSize, nonceSize+len(dk)+tagSize)
+	if _, err := rand.Read(b); nil != err {
+		return nil, AsError(err)
+	}
+
+	return w.ci.Seal(b, b[:nonceSize], dk, []byte(keyWrapAD)), nil
+}
+
+// UnwrapKey implements KeyWrapper.
+func (w LocalKeyWrapper) UnwrapKey(wrapped []byte) ([]byte, error) {
+	if nil == w.ci {
+		panic(ErrEngNotInitialized)
+	}
+
+	if len(wrapped) < nonceSize+tagSize {
+		return nil, ErrBadWrappedKey
+	}
+
+	dk, err := w.ci.Open(nil,
+		wrapped[:nonceSize], wrapped[nonceSize:], []byte(keyWrapAD))
+	if nil != err {
+		return nil, ErrBadWrappedKey
+	}
+
+	return dk, nil
+}
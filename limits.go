@@ -0,0 +1,58 @@
+package fpast2l
+
+// Limits bounds the size of the tokens that Decrypt accepts.
+// They are checked before anything is decoded or allocated,
+// and tokens that exceed them fail with ErrTokenTooLarge.
+// Zero (or negative) fields do not limit anything.
+type Limits struct {
+	// MaxTokenSize limits the length of the token.
+	MaxTokenSize int
+
+	// MaxFooterSize limits the length of the decoded footer,
+	// including footer flags (see Engine.WithCompression).
+	MaxFooterSize int
+
+	// MaxPayloadSize limits the length of the decrypted payload
+	// before padding is stripped.
+	// Decompressed payloads are also limited to MaxPayloadSize
+	// if it is lower than the limit set by Engine.WithMaxInflateSize.
+	MaxPayloadSize int
+}
+
+// WithLimits returns a copy of Engine
+// whose Decrypt rejects tokens exceeding l.
+func (eng Engine) WithLimits(l Limits) Engine { eng.limits = l; return eng }
+
+// checkToken returns ErrTokenTooLarge
+// if a token of n bytes exceeds l.
+func (l Limits) checkToken(n int) error {
+	if exceeds(n, l.MaxTokenSize) {
+		return ErrTokenTooLarge
+	}
+
+	return nil
+}
+
+// checkParts returns ErrTokenTooLarge
+// if a payload of n bytes or a footer of m bytes exceeds l.
+func (l Limits) checkParts(n, m int) error {
+	if exceeds(n, l.MaxPayloadSize) || exceeds(m, l.MaxFooterSize) {
+		return ErrTokenTooLarge
+	}
+
+	return nil
+}
+
+// maxInflateSize returns max
+// lowered to MaxPayloadSize if necessary.
+func (l Limits) maxInflateSize(max int) int {
+	if exceeds(max, l.MaxPayloadSize) {
+		return l.MaxPayloadSize
+	}
+
+	return max
+}
+
+// exceeds reports whether n exceeds the limit max,
+// which is no limit if not positive.
+func exceeds(n, max int) bool { return max > 0 && n > max }
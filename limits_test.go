@@ -0,0 +1,164 @@
+package fpast2l
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestEngineWithLimits(t *testing.T) {
+	t.Parallel()
+
+	k := randomBytes(make([]byte, KeySize))
+	f := randomString(32)
+	b := randomBytes(make([]byte, 100))
+
+	s := New(k).WithFooter(f).Encrypt(copyBuffer(b))
+	huge := header + strings.Repeat("A", 1<<20)
+
+	for i, c := range [...]struct {
+		limits Limits
+		s      string
+		err    error
+	}{
+		{Limits{}, s, nil},
+		{Limits{}, huge, ErrBadEncryption},
+		{Limits{MaxTokenSize: len(s)}, s, nil},
+		{Limits{MaxTokenSize: len(s) - 1}, s, ErrTokenTooLarge},
+		{Limits{MaxTokenSize: 8 << 10}, huge, ErrTokenTooLarge},
+		{Limits{MaxFooterSize: len(f)}, s, nil},
+		{Limits{MaxFooterSize: len(f) - 1}, s, ErrTokenTooLarge},
+		{Limits{MaxPayloadSize: len(b)}, s, nil},
+		{Limits{MaxPayloadSize: len(b) - 1}, s, ErrTokenTooLarge},
+		{Limits{MaxPayloadSize: 4 << 10}, huge, ErrTokenTooLarge},
+		{Limits{MaxTokenSize: -1, MaxFooterSize: -1, MaxPayloadSize: -1}, s, nil},
+	} {
+		eng := New(k).WithLimits(c.limits)
+		r, err := eng.Decrypt(nil, c.s)
+		if err != c.err {
+			t.Errorf("i=%d: expected err = %v, actual %v", i, c.err, err)
+			continue
+		}
+
+		if nil == err && !bytes.Equal(r, b) {
+			t.Errorf("i=%d: expected %x, actual %x", i, b, r)
+		}
+	}
+
+	if act := ErrorKind(ErrTokenTooLarge); act != "token_too_large" {
+		t.Errorf("expected kind token_too_large, actual %q", act)
+	}
+}
+
+func TestEngineWithLimitsAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocation counts are unreliable with -race")
+	}
+
+	eng := New(randomBytes(make([]byte, KeySize))).
+		WithLimits(Limits{MaxTokenSize: 8 << 10, MaxPayloadSize: 1 << 10})
+
+	for i, s := range [...]string{
+		header + strings.Repeat("A", 8<<10),
+		header + strings.Repeat("A", 4<<10),
+	} {
+		n := testing.AllocsPerRun(10, func() {
+			if _, err := eng.Decrypt(nil, s); err != ErrTokenTooLarge {
+				panic(err)
+			}
+		})
+
+		// returning an Error as error boxes it, nothing else may allocate
+		if n > 1 {
+			t.Errorf("i=%d: expected at most 1 alloc, actual %v", i, n)
+		}
+	}
+}
+
+func TestDecoderWithLimitsAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocation counts are unreliable with -race")
+	}
+
+	k := randomBytes(make([]byte, KeySize))
+	eng := New(k).WithLimits(Limits{MaxTokenSize: 8 << 10})
+	huge := header + strings.Repeat("A", 1<<20)
+
+	d := NewDecoder(eng)
+	n := testing.AllocsPerRun(10, func() {
+		if _, err := d.Decrypt(huge); err != ErrTokenTooLarge {
+			panic(err)
+		}
+	})
+
+	if n > 1 || 0 != cap(d.buf) {
+		t.Errorf("expected at most 1 alloc and no buffer, actual %v and cap %d", n, cap(d.buf))
+	}
+
+	s := New(k).Encrypt(randomBytes(make([]byte, 100)))
+	r, bs, errs := eng.DecryptBatch(nil, []string{huge, s, huge}, 1)
+	if k := len(s) + minPAESize; cap(r) != k {
+		t.Errorf("expected arena of %d bytes, actual %d", k, cap(r))
+	}
+
+	for i, err := range [...]error{ErrTokenTooLarge, nil, ErrTokenTooLarge} {
+		if errs[i] != err || (nil != err) != (nil == bs[i]) {
+			t.Errorf("i=%d: expected err = %v, actual %v (%x)", i, err, errs[i], bs[i])
+		}
+	}
+}
+
+func TestEngineWithLimitsCompression(t *testing.T) {
+	t.Parallel()
+
+	k := randomBytes(make([]byte, KeySize))
+	b := bytes.Repeat([]byte("a"), 4<<10)
+	s := New(k).WithCompression(flate.BestCompression).Encrypt(copyBuffer(b))
+
+	eng := New(k).WithLimits(Limits{MaxPayloadSize: 1 << 10})
+	if _, err := eng.Decrypt(nil, s); err != ErrPayloadTooLarge {
+		t.Errorf("expected ErrPayloadTooLarge, actual %v", err)
+	}
+
+	eng = eng.WithLimits(Limits{MaxPayloadSize: 4 << 10})
+	if r, err := eng.Decrypt(nil, s); nil != err || !bytes.Equal(r, b) {
+		t.Errorf("expected payload, actual err = %v", err)
+	}
+}
+
+func TestKeyringWithLimits(t *testing.T) {
+	t.Parallel()
+
+	kr, err := ParseKeyring(strings.NewReader(fmt.Sprintf(
+		`{"keys": [{"id": "k0", "status": "active", "key": %q}]}`,
+		FormatKey(randomBytes(make([]byte, KeySize))))))
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	s, err := kr.Encrypt(randomBytes(make([]byte, 100)))
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	limited := kr.WithLimits(Limits{MaxPayloadSize: 99})
+	if _, err := limited.Decrypt(nil, s); err != ErrTokenTooLarge {
+		t.Errorf("expected ErrTokenTooLarge, actual %v", err)
+	}
+
+	if _, err := kr.Decrypt(nil, s); nil != err {
+		t.Errorf("expected original keyring to be unlimited, actual %v", err)
+	}
+
+	env := NewEnvelope(NewLocalKeyWrapper(randomBytes(make([]byte, KeySize))))
+	if s, err = env.Encrypt(randomBytes(make([]byte, 100))); nil != err {
+		t.Fatal(err)
+	}
+
+	env = env.WithLimits(Limits{MaxTokenSize: len(s) - 1})
+	if _, err := env.Decrypt(nil, s); err != ErrTokenTooLarge {
+		t.Errorf("expected ErrTokenTooLarge, actual %v", err)
+	}
+}
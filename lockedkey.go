@@ -0,0 +1,176 @@
+package fpast2l
+
+import (
+	"crypto/cipher"
+	"runtime"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// LockedKey holds an encryption key outside the Go heap,
+// in memory that is locked into RAM (mlock)
+// and excluded from core dumps (MADV_DONTDUMP),
+// so that it ends up neither in swap nor in core dumps.
+//
+// Where that is not supported (anywhere but Linux)
+// or not permitted (e.g. RLIMIT_MEMLOCK is exhausted),
+// LockedKey falls back to ordinary memory; see Locked.
+//
+// LockedKey is safe for concurrent use.
+type LockedKey struct {
+	mu     sync.RWMutex // guards b against Destroy
+	b      []byte
+	mapped bool // b was allocated by allocLocked, see freeLocked
+	locked bool
+}
+
+// NewLockedKey copies K into a new LockedKey and returns it.
+// K itself is left as is; the caller should zero it.
+// NewLockedKey will panic if len(K) is not exactly KeySize bytes.
+//
+// The key is zeroed and its memory released by Destroy
+// or, failing that, once the LockedKey is garbage collected.
+func NewLockedKey(K []byte) *LockedKey {
+	if len(K) != KeySize {
+		panic(ErrBadKeySize)
+	}
+
+	lk := new(LockedKey)
+	lk.b, lk.mapped, lk.locked = allocLocked(KeySize)
+	copy(lk.b, K)
+
+	runtime.SetFinalizer(lk, (*LockedKey).Destroy)
+	return lk
+}
+
+// Locked reports whether the key is held in locked memory
+// that is excluded from core dumps.
+func (lk *LockedKey) Locked() bool { return lk.locked }
+
+// Destroy zeroes the key and releases its memory.
+// Engines created from lk panic (ErrEngNotInitialized) once it is destroyed.
+func (lk *LockedKey) Destroy() {
+	lk.mu.Lock()
+	defer lk.mu.Unlock()
+
+	if nil == lk.b {
+		return
+	}
+
+	zero(lk.b)
+	if lk.mapped {
+		freeLocked(lk.b)
+	}
+
+	lk.b, lk.mapped, lk.locked = nil, false, false
+}
+
+// NewLocked constructs and returns a new Engine
+// that encrypts and decrypts with the key held by lk.
+//
+// The key never leaves lk:
+// each token is sealed with a subkey derived from it (see HChaCha20),
+// which, unlike the key, is not protected.
+// NewLocked is slower than New and allocates per token.
+func NewLocked(lk *LockedKey) Engine {
+	if nil == lk {
+		panic(ErrEngNotInitialized)
+	}
+
+	return NewWithAEAD(lockedAEAD{lk})
+}
+
+// lockedAEAD is XChaCha20-Poly1305 keyed by a LockedKey.
+type lockedAEAD struct{ lk *LockedKey }
+
+func (lockedAEAD) NonceSize() int { return nonceSize }
+func (lockedAEAD) Overhead() int  { return tagSize }
+
+func (a lockedAEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	ci, n := a.subkey(nonce)
+	return ci.Seal(dst, n[:], plaintext, additionalData)
+}
+
+func (a lockedAEAD) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	ci, n := a.subkey(nonce)
+	return ci.Open(dst, n[:], ciphertext, additionalData)
+}
+
+// subkey derives the ChaCha20-Poly1305 AEAD and nonce
+// that XChaCha20-Poly1305 uses for the given nonce.
+func (a lockedAEAD) subkey(nonce []byte) (cipher.AEAD, [chacha20poly1305.NonceSize]byte) {
+	var (
+		sk [KeySize]byte
+		n  [chacha20poly1305.NonceSize]byte
+	)
+
+	if len(nonce) != nonceSize {
+		panic(errBadNonceLength)
+	}
+
+	a.lk.mu.RLock()
+	if nil == a.lk.b {
+		a.lk.mu.RUnlock()
+		panic(ErrEngNotInitialized)
+	}
+
+	hChaCha20(&sk, a.lk.b, nonce[:16])
+	a.lk.mu.RUnlock()
+
+	ci, err := chacha20poly1305.New(sk[:])
+	zero(sk[:])
+	if nil != err {
+		panic(AsError(err))
+	}
+
+	copy(n[4:], nonce[16:])
+	return ci, n
+}
+
+// hChaCha20 derives the subkey out from key and the 16-byte nonce
+// as described in draft-irtf-cfrg-xchacha, sec. 2.2.
+func hChaCha20(out *[KeySize]byte, key, nonce []byte) {
+	x := [16]uint32{
+		0x61707865, 0x3320646e, 0x79622d32, 0x6b206574,
+		le.Uint32(key[0:]), le.Uint32(key[4:]),
+		le.Uint32(key[8:]), le.Uint32(key[12:]),
+		le.Uint32(key[16:]), le.Uint32(key[20:]),
+		le.Uint32(key[24:]), le.Uint32(key[28:]),
+		le.Uint32(nonce[0:]), le.Uint32(nonce[4:]),
+		le.Uint32(nonce[8:]), le.Uint32(nonce[12:]),
+	}
+
+	for i := 0; i < 10; i++ {
+		quarterRound(&x, 0, 4, 8, 12)
+		quarterRound(&x, 1, 5, 9, 13)
+		quarterRound(&x, 2, 6, 10, 14)
+		quarterRound(&x, 3, 7, 11, 15)
+		quarterRound(&x, 0, 5, 10, 15)
+		quarterRound(&x, 1, 6, 11, 12)
+		quarterRound(&x, 2, 7, 8, 13)
+		quarterRound(&x, 3, 4, 9, 14)
+	}
+
+	for i, v := range [...]uint32{x[0], x[1], x[2], x[3], x[12], x[13], x[14], x[15]} {
+		le.PutUint32(out[4*i:], v)
+	}
+
+	x = [16]uint32{}
+}
+
+// quarterRound is the ChaCha quarter round on x[a], x[b], x[c] and x[d].
+func quarterRound(x *[16]uint32, a, b, c, d int) {
+	x[a] += x[b]
+	x[d] ^= x[a]
+	x[d] = x[d]<<16 | x[d]>>16
+	x[c] += x[d]
+	x[b] ^= x[c]
+	x[b] = x[b]<<12 | x[b]>>20
+	x[a] += x[b]
+	x[d] ^= x[a]
+	x[d] = x[d]<<8 | x[d]>>24
+	x[c] += x[d]
+	x[b] ^= x[c]
+	x[b] = x[b]<<7 | x[b]>>25
+}
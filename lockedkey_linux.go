@@ -0,0 +1,26 @@
+package fpast2l
+
+import "golang.org/x/sys/unix"
+
+// allocLocked allocates n bytes of anonymous memory
+// outside the Go heap
+// and tries to lock it and exclude it from core dumps.
+// If the memory cannot be mapped,
+// it falls back to the Go heap (mapped is false).
+func allocLocked(n int) (b []byte, mapped, locked bool) {
+	b, err := unix.Mmap(-1, 0, n,
+		unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE)
+	if nil != err {
+		return make([]byte, n), false, false
+	}
+
+	locked = nil == unix.Mlock(b)
+	locked = nil == unix.Madvise(b, unix.MADV_DONTDUMP) && locked
+	return b, true, locked
+}
+
+// freeLocked releases memory allocated by allocLocked.
+func freeLocked(b []byte) {
+	_ = unix.Munlock(b)
+	_ = unix.Munmap(b)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package fpast2l
+
+// allocLocked allocates n bytes on the Go heap;
+// memory locking is only supported on Linux.
+func allocLocked(n int) (b []byte, mapped, locked bool) {
+	return make([]byte, n), false, false
+}
+
+// freeLocked is a no-op, since allocLocked never maps memory.
+func freeLocked(b []byte) {}
@@ -0,0 +1,90 @@
+package fpast2l
+
+import (
+	"bytes"
+	"encoding/hex"
+	"runtime"
+	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+func Test_hChaCha20(t *testing.T) {
+	t.Parallel()
+
+	// draft-irtf-cfrg-xchacha-03, sec. 2.2.1
+	key, _ := hex.DecodeString(
+		"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	nonce, _ := hex.DecodeString("000000090000004a0000000031415927")
+	exp := "82413b4227b27bfed30e42508a877d73a0f9e4d58a74a853c12ec41326d3ecdc"
+
+	var out [KeySize]byte
+	hChaCha20(&out, key, nonce)
+	if act := hex.EncodeToString(out[:]); act != exp {
+		t.Errorf("expected %s, actual %s", exp, act)
+	}
+}
+
+func TestLockedKey(t *testing.T) {
+	t.Parallel()
+
+	k := randomBytes(make([]byte, KeySize))
+	lk := NewLockedKey(k)
+	defer lk.Destroy()
+
+	if runtime.GOOS == "linux" && !lk.Locked() {
+		t.Log("key memory could not be locked (RLIMIT_MEMLOCK?)")
+	}
+
+	ref, err := chacha20poly1305.NewX(k)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	ci := lockedAEAD{lk}
+	for i := 0; i < 64; i++ {
+		b := randomBytes(make([]byte, i*13))
+		ad := randomBytes(make([]byte, i))
+		nonce := randomBytes(make([]byte, nonceSize))
+
+		exp := ref.Seal(nil, nonce, b, ad)
+		act := ci.Seal(nil, nonce, b, ad)
+		if !bytes.Equal(act, exp) {
+			t.Fatalf("i=%d: expected Hex(%q), actual Hex(%q)", i,
+				hex.EncodeToString(exp), hex.EncodeToString(act))
+		}
+
+		r, err := ci.Open(nil, nonce, exp, ad)
+		if nil != err || !bytes.Equal(r, b) {
+			t.Fatalf("i=%d: expected %x, actual %x (err = %v)", i, b, r, err)
+		}
+
+		exp[0] ^= 1
+		if _, err := ci.Open(nil, nonce, exp, ad); nil == err {
+			t.Errorf("i=%d: expected tampered ciphertext to fail", i)
+		}
+	}
+
+	eng := NewLocked(lk).WithFooter("locked")
+	b := randomBytes(make([]byte, 64))
+	s := eng.Encrypt(copyBuffer(b))
+
+	if r, err := New(k).Decrypt(nil, s); nil != err || !bytes.Equal(r, b) {
+		t.Errorf("expected New to decrypt, actual err = %v", err)
+	}
+
+	if r, err := eng.Decrypt(nil, rPASTEncrypt(k, b, "")); nil != err || !bytes.Equal(r, b) {
+		t.Errorf("expected NewLocked to decrypt, actual err = %v", err)
+	}
+
+	lk.Destroy()
+	lk.Destroy() // no-op
+
+	defer func() {
+		if r := recover(); r != ErrEngNotInitialized {
+			t.Errorf("expected panic ErrEngNotInitialized, actual %v", r)
+		}
+	}()
+
+	eng.Encrypt(b)
+}
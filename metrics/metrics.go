@@ -0,0 +1,384 @@
+// Package metrics implements counters, gauges and histograms
+// that are exposed in the Prometheus text exposition format,
+// without depending on the Prometheus client library.
+//
+// Only what is needed by fpast2l and its commands is implemented:
+// metrics are registered once, label values are plain strings
+// and all updates are lock-free once a series exists.
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType is the content type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultDurationBuckets are histogram buckets, in seconds,
+// suitable for the latency of in-process operations and requests.
+var DefaultDurationBuckets = []float64{
+	.00001, .000025, .00005, .0001, .00025, .0005,
+	.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1,
+}
+
+// Registry is a set of metrics that can be written out together.
+// Registry is safe for concurrent use.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+// metric is implemented by every metric (vector) type.
+type metric interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{names: map[string]bool{}}
+}
+
+// register adds m to r.
+// It panics if a metric with the same name is already registered.
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.names[m.name()] {
+		panic("metrics: duplicate metric " + m.name())
+	}
+
+	r.names[m.name()] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteTo writes all metrics of r to w in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP implements http.Handler.
+// It responds with the metrics of r.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	_, _ = r.WriteTo(w)
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// vec holds the series of a metric, keyed by their label values.
+type vec struct {
+	mname, help, typ string
+	labels           []string
+
+	mu     sync.RWMutex
+	series map[string]*series
+	newFn  func() interface{}
+}
+
+// series is a single labelled series of a metric.
+type series struct {
+	values []string
+	v      interface{} // *Counter, *Gauge or *Histogram
+}
+
+func newVec(r *Registry, name, help, typ string, labels []string,
+	newFn func() interface{}) *vec {
+	v := &vec{
+		mname:  name,
+		help:   help,
+		typ:    typ,
+		labels: append([]string(nil), labels...),
+		series: map[string]*series{},
+		newFn:  newFn,
+	}
+
+	if nil != r {
+		r.register(v)
+	}
+
+	return v
+}
+
+func (v *vec) name() string { return v.mname }
+
+// with returns the series for the label values,
+// creating it if necessary.
+// It panics if the number of values does not match the labels.
+func (v *vec) with(values []string) interface{} {
+	if len(values) != len(v.labels) {
+		panic("metrics: wrong number of label values for " + v.mname)
+	}
+
+	key := ""
+	switch len(values) {
+	case 0:
+	case 1:
+		key = values[0]
+	default:
+		key = strings.Join(values, "\xff")
+	}
+
+	v.mu.RLock()
+	s, ok := v.series[key]
+	v.mu.RUnlock()
+	if ok {
+		return s.v
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok = v.series[key]; !ok {
+		s = &series{values: append([]string(nil), values...), v: v.newFn()}
+		v.series[key] = s
+	}
+
+	return s.v
+}
+
+func (v *vec) write(w *bufio.Writer) {
+	v.mu.RLock()
+	series := make([]*series, 0, len(v.series))
+	for _, s := range v.series {
+		series = append(series, s)
+	}
+	v.mu.RUnlock()
+
+	sort.Slice(series, func(i, j int) bool {
+		a, b := series[i].values, series[j].values
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+
+		return false
+	})
+
+	w.WriteString("# HELP " + v.mname + " " + escapeHelp(v.help) + "\n")
+	w.WriteString("# TYPE " + v.mname + " " + v.typ + "\n")
+	for _, s := range series {
+		switch m := s.v.(type) {
+		case *Counter:
+			writeSample(w, v.mname, v.labels, s.values, "", "", m.Value())
+		case *Gauge:
+			writeSample(w, v.mname, v.labels, s.values, "", "", m.Value())
+		case *Histogram:
+			m.write(w, v.mname, v.labels, s.values)
+		}
+	}
+}
+
+// writeSample writes a single sample line.
+// If extraLabel is not empty, it is appended to the labels.
+func writeSample(w *bufio.Writer, name string, labels, values []string,
+	extraLabel, extraValue string, value float64) {
+	w.WriteString(name)
+	if 0 != len(labels) || 0 != len(extraLabel) {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if 0 != i {
+				w.WriteByte(',')
+			}
+
+			w.WriteString(l + `="` + escapeLabel(values[i]) + `"`)
+		}
+
+		if 0 != len(extraLabel) {
+			if 0 != len(labels) {
+				w.WriteByte(',')
+			}
+
+			w.WriteString(extraLabel + `="` + escapeLabel(extraValue) + `"`)
+		}
+
+		w.WriteByte('}')
+	}
+
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, +1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+// Counter is a monotonically increasing counter.
+type Counter struct{ n uint64 }
+
+// Inc increments c by 1.
+func (c *Counter) Inc() { atomic.AddUint64(&c.n, 1) }
+
+// Add increments c by n.
+func (c *Counter) Add(n uint64) { atomic.AddUint64(&c.n, n) }
+
+// Value returns the current value of c.
+func (c *Counter) Value() float64 { return float64(atomic.LoadUint64(&c.n)) }
+
+// CounterVec is a set of Counters partitioned by label values.
+type CounterVec struct{ v *vec }
+
+// NewCounterVec creates a CounterVec and registers it with r (if non-nil).
+func NewCounterVec(r *Registry, name, help string, labels ...string) CounterVec {
+	return CounterVec{newVec(r, name, help, "counter", labels,
+		func() interface{} { return new(Counter) })}
+}
+
+// With returns the Counter for the label values.
+func (cv CounterVec) With(values ...string) *Counter {
+	return cv.v.with(values).(*Counter)
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct{ bits uint64 }
+
+// Set sets g to f.
+func (g *Gauge) Set(f float64) { atomic.StoreUint64(&g.bits, math.Float64bits(f)) }
+
+// Add adds f (which may be negative) to g.
+func (g *Gauge) Add(f float64) {
+	for {
+		old := atomic.LoadUint64(&g.bits)
+		new := math.Float64bits(math.Float64frombits(old) + f)
+		if atomic.CompareAndSwapUint64(&g.bits, old, new) {
+			return
+		}
+	}
+}
+
+// Inc increments g by 1.
+func (g *Gauge) Inc() { g.Add(1) }
+
+// Dec decrements g by 1.
+func (g *Gauge) Dec() { g.Add(-1) }
+
+// Value returns the current value of g.
+func (g *Gauge) Value() float64 { return math.Float64frombits(atomic.LoadUint64(&g.bits)) }
+
+// GaugeVec is a set of Gauges partitioned by label values.
+type GaugeVec struct{ v *vec }
+
+// NewGaugeVec creates a GaugeVec and registers it with r (if non-nil).
+func NewGaugeVec(r *Registry, name, help string, labels ...string) GaugeVec {
+	return GaugeVec{newVec(r, name, help, "gauge", labels,
+		func() interface{} { return new(Gauge) })}
+}
+
+// With returns the Gauge for the label values.
+func (gv GaugeVec) With(values ...string) *Gauge {
+	return gv.v.with(values).(*Gauge)
+}
+
+// Histogram counts observations in cumulative buckets.
+type Histogram struct {
+	upper  []float64 // bucket upper bounds, ascending
+	counts []uint64  // non-cumulative, len(upper)+1 (last is +Inf)
+	count  uint64
+	sum    Gauge
+}
+
+// Observe records the observation f.
+func (h *Histogram) Observe(f float64) {
+	i := sort.SearchFloat64s(h.upper, f)
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	h.sum.Add(f)
+}
+
+// Count returns the number of observations.
+func (h *Histogram) Count() uint64 { return atomic.LoadUint64(&h.count) }
+
+// Sum returns the sum of observations.
+func (h *Histogram) Sum() float64 { return h.sum.Value() }
+
+func (h *Histogram) write(w *bufio.Writer, name string, labels, values []string) {
+	cum := uint64(0)
+	for i, upper := range h.upper {
+		cum += atomic.LoadUint64(&h.counts[i])
+		writeSample(w, name+"_bucket", labels, values,
+			"le", formatFloat(upper), float64(cum))
+	}
+
+	cum += atomic.LoadUint64(&h.counts[len(h.upper)])
+	writeSample(w, name+"_bucket", labels, values, "le", "+Inf", float64(cum))
+	writeSample(w, name+"_sum", labels, values, "", "", h.Sum())
+	writeSample(w, name+"_count", labels, values, "", "", float64(cum))
+}
+
+// HistogramVec is a set of Histograms partitioned by label values.
+type HistogramVec struct{ v *vec }
+
+// NewHistogramVec creates a HistogramVec with the given bucket upper bounds
+// and registers it with r (if non-nil).
+// It panics unless buckets are strictly ascending.
+func NewHistogramVec(r *Registry, name, help string, buckets []float64,
+	labels ...string) HistogramVec {
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			panic("metrics: buckets not ascending for " + name)
+		}
+	}
+
+	upper := append([]float64(nil), buckets...)
+	return HistogramVec{newVec(r, name, help, "histogram", labels,
+		func() interface{} {
+			return &Histogram{
+				upper:  upper,
+				counts: make([]uint64, len(upper)+1),
+			}
+		})}
+}
+
+// With returns the Histogram for the label values.
+func (hv HistogramVec) With(values ...string) *Histogram {
+	return hv.v.with(values).(*Histogram)
+}
@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zrhmn/fpast2l"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+
+	r := NewRegistry()
+	c := NewCounterVec(r, "requests_total", "Requests.\nBy code.", "code")
+	g := NewGaugeVec(r, "in_flight", "In-flight requests.")
+	h := NewHistogramVec(r, "latency_seconds", "Latency.", []float64{.1, 1}, "path")
+
+	c.With("200").Add(3)
+	c.With("401").Inc()
+	g.With().Inc()
+	g.With().Inc()
+	g.With().Dec()
+	h.With(`/a"b\`).Observe(.05)
+	h.With(`/a"b\`).Observe(.5)
+	h.With(`/a"b\`).Observe(5)
+
+	exp := strings.Join([]string{
+		`# HELP requests_total Requests.\nBy code.`,
+		`# TYPE requests_total counter`,
+		`requests_total{code="200"} 3`,
+		`requests_total{code="401"} 1`,
+		`# HELP in_flight In-flight requests.`,
+		`# TYPE in_flight gauge`,
+		`in_flight 1`,
+		`# HELP latency_seconds Latency.`,
+		`# TYPE latency_seconds histogram`,
+		`latency_seconds_bucket{path="/a\"b\\",le="0.1"} 1`,
+		`latency_seconds_bucket{path="/a\"b\\",le="1"} 2`,
+		`latency_seconds_bucket{path="/a\"b\\",le="+Inf"} 3`,
+		`latency_seconds_sum{path="/a\"b\\"} 5.55`,
+		`latency_seconds_count{path="/a\"b\\"} 3`,
+		``,
+	}, "\n")
+
+	buf := new(bytes.Buffer)
+	n, err := r.WriteTo(buf)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	if act := buf.String(); act != exp {
+		t.Errorf("expected\n%s\nactual\n%s", exp, act)
+	}
+
+	if int(n) != buf.Len() {
+		t.Errorf("expected n = %d, actual %d", buf.Len(), n)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("expected Content-Type %q, actual %q", ContentType, ct)
+	}
+
+	if act := w.Body.String(); act != exp {
+		t.Errorf("expected\n%s\nactual\n%s", exp, act)
+	}
+
+	func() {
+		defer func() {
+			if nil == recover() {
+				t.Errorf("expected panic on duplicate metric")
+			}
+		}()
+
+		NewCounterVec(r, "requests_total", "")
+	}()
+
+	func() {
+		defer func() {
+			if nil == recover() {
+				t.Errorf("expected panic on wrong number of labels")
+			}
+		}()
+
+		c.With("200", "GET")
+	}()
+}
+
+func TestObserver(t *testing.T) {
+	t.Parallel()
+
+	k := make([]byte, fpast2l.KeySize)
+	if _, err := rand.Read(k); nil != err {
+		t.Fatal(err)
+	}
+
+	r := NewRegistry()
+	eng := fpast2l.New(k).WithObserver(NewObserver(r))
+
+	s := eng.Encrypt(make([]byte, 100))
+	_, _ = eng.Decrypt(nil, s)
+	_, _ = eng.Decrypt(nil, s[:len(s)-1])
+	_, _ = eng.Decrypt(nil, "v1.local.")
+
+	buf := new(bytes.Buffer)
+	if _, err := r.WriteTo(buf); nil != err {
+		t.Fatal(err)
+	}
+
+	for _, line := range [...]string{
+		`fpast2l_tokens_total{op="decrypt",result="bad_encryption"} 1`,
+		`fpast2l_tokens_total{op="decrypt",result="bad_header"} 1`,
+		`fpast2l_tokens_total{op="decrypt",result="ok"} 1`,
+		`fpast2l_tokens_total{op="encrypt",result="ok"} 1`,
+		`fpast2l_duration_seconds_count{op="decrypt"} 3`,
+		`fpast2l_payload_bytes_bucket{op="decrypt",le="128"} 1`,
+		`fpast2l_payload_bytes_count{op="decrypt"} 1`,
+		`fpast2l_token_bytes_count{op="decrypt"} 3`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("expected line %q in\n%s", line, buf)
+		}
+	}
+}
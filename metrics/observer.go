@@ -0,0 +1,52 @@
+package metrics
+
+import "github.com/zrhmn/fpast2l"
+
+// SizeBuckets are histogram buckets, in bytes,
+// suitable for payload and token sizes.
+var SizeBuckets = []float64{64, 128, 256, 512, 1 << 10, 2 << 10, 4 << 10, 8 << 10}
+
+// Observer is a fpast2l.Observer
+// that records observations as metrics:
+//
+//	fpast2l_tokens_total{op, result}   counter
+//	fpast2l_duration_seconds{op}       histogram
+//	fpast2l_payload_bytes{op}          histogram (successful calls)
+//	fpast2l_token_bytes{op}            histogram
+//
+// where result is "ok" or the fpast2l.ErrorKind of the error.
+type Observer struct {
+	tokens    CounterVec
+	duration  HistogramVec
+	size      HistogramVec
+	tokenSize HistogramVec
+}
+
+// NewObserver creates an Observer whose metrics are registered with r.
+func NewObserver(r *Registry) *Observer {
+	return &Observer{
+		tokens: NewCounterVec(r, "fpast2l_tokens_total",
+			"Number of tokens encrypted or decrypted, by result.",
+			"op", "result"),
+		duration: NewHistogramVec(r, "fpast2l_duration_seconds",
+			"Time spent encrypting or decrypting a token.",
+			DefaultDurationBuckets, "op"),
+		size: NewHistogramVec(r, "fpast2l_payload_bytes",
+			"Size of the plaintext payload of a token.",
+			SizeBuckets, "op"),
+		tokenSize: NewHistogramVec(r, "fpast2l_token_bytes",
+			"Size of an encoded token.",
+			SizeBuckets, "op"),
+	}
+}
+
+// Observe implements fpast2l.Observer.
+func (o *Observer) Observe(ob fpast2l.Observation) {
+	op := ob.Op.String()
+	o.tokens.With(op, ob.Kind()).Inc()
+	o.duration.With(op).Observe(ob.Duration.Seconds())
+	o.tokenSize.With(op).Observe(float64(ob.TokenSize))
+	if nil == ob.Err {
+		o.size.With(op).Observe(float64(ob.Size))
+	}
+}
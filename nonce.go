@@ -1,9 +1,48 @@
 package fpast2l
 
 import (
+	"bytes"
 	"crypto/rand"
+	"encoding"
+	"encoding/binary"
+	"hash"
+	"sync"
 
 	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/salsa20"
+)
+
+const (
+	// drbgBufSize is the amount of keystream generated per refill.
+	drbgBufSize = 1 << 10
+
+	// drbgReseedSize is the number of bytes a drbg serves
+	// before it is reseeded from crypto/rand.
+	drbgReseedSize = 1 << 20
+
+	// blake2bStateSize is the size of the marshaled state
+	// of a golang.org/x/crypto/blake2b hash.
+	blake2bStateSize = 3 + 8*8 + 2*8 + 1 + blake2b.BlockSize + 1
+)
+
+var (
+	errBadBLAKE2b = internal("blake2b hash cannot be restored")
+
+	// nonceGenerators pools nonceGenerators,
+	// effectively giving each P its own.
+	nonceGenerators = sync.Pool{
+		New: func() interface{} { return newNonceGenerator() },
+	}
+
+	// blake2bIV is the BLAKE2b initialization vector.
+	blake2bIV = [8]uint64{
+		0x6a09e667f3bcc908, 0xbb67ae8584caa73b,
+		0x3c6ef372fe94f82b, 0xa54ff53a5f1d36f1,
+		0x510e527fade682d1, 0x9b05688c2b3e6c1f,
+		0x1f83d9abfb41bd6b, 0x5be0cd19137e2179,
+	}
+
+	zeroSalsaNonce [8]byte
 )
 
 // generateNonce generates encryption nonce
@@ -13,22 +52,206 @@ import (
 // using a crypto-safe pseudorandom key
 // appending the result to p
 // and returning it as b.
+//
+// The key is drawn from a pooled drbg
+// and the BLAKE2b hasher is reused,
+// so generateNonce does not allocate
+// if p has sufficient capacity.
 func generateNonce(p, m []byte) (b []byte) {
 	p, b = extend(p, nonceSize)
 	b = b[len(p):][:nonceSize]
 
-	if _, err := rand.Read(b); nil != err {
+	g := nonceGenerators.Get().(*nonceGenerator)
+	defer nonceGenerators.Put(g)
+
+	g.rand.read(b)
+	h := g.mac.reset(b)
+	if _, err := h.Write(m); nil != err {
 		panic(AsError(err))
 	}
 
-	h, err := blake2b.New(nonceSize, b)
+	return h.Sum(p)
+}
+
+// nonceGenerator holds the reusable state of generateNonce.
+// It must not be used concurrently.
+type nonceGenerator struct {
+	rand drbg
+	mac  keyedBLAKE2b
+}
+
+func newNonceGenerator() *nonceGenerator {
+	g := new(nonceGenerator)
+	g.rand.init()
+	g.mac.init(nonceSize, nonceSize)
+	return g
+}
+
+// drbg is a fast-key-erasure random generator:
+// it fills a buffer with Salsa20 keystream,
+// replaces its key with the first bytes of the buffer
+// and erases every byte it serves.
+// It is seeded from crypto/rand
+// and reseeded after serving drbgReseedSize bytes.
+//
+// drbg must not be used concurrently.
+type drbg struct {
+	key [32]byte
+	buf [drbgBufSize]byte
+	off int // start of unserved bytes in buf
+	n   int // bytes served since last reseed
+}
+
+// init prepares r to be seeded on first read.
+func (r *drbg) init() {
+	r.off, r.n = drbgBufSize, drbgReseedSize
+}
+
+// read fills b with random bytes.
+func (r *drbg) read(b []byte) {
+	for 0 != len(b) {
+		if drbgBufSize == r.off {
+			r.refill()
+		}
+
+		n := copy(b, r.buf[r.off:])
+		zero(r.buf[r.off:][:n])
+		r.off += n
+		r.n += n
+		b = b[n:]
+	}
+}
+
+// refill generates a new buffer of keystream
+// and rotates the key, reseeding first if necessary.
+func (r *drbg) refill() {
+	if r.n >= drbgReseedSize {
+		if _, err := rand.Read(r.key[:]); nil != err {
+			panic(AsError(err))
+		}
+
+		r.n = 0
+	}
+
+	zero(r.buf[:])
+	salsa20.XORKeyStream(r.buf[:], r.buf[:], zeroSalsaNonce[:], &r.key)
+
+	r.off = copy(r.key[:], r.buf[:])
+	zero(r.buf[:r.off])
+}
+
+// keyedBLAKE2b is a keyed BLAKE2b hash
+// whose key can be replaced without allocating.
+//
+// Since golang.org/x/crypto/blake2b cannot re-key a hash,
+// reset restores an unkeyed hash (via encoding.BinaryUnmarshaler)
+// to the initial state of a hash keyed as described in RFC 7693:
+// the parameter block includes the key length
+// and the key, padded with zeroes, is the first block.
+//
+// The marshaled state is not part of the blake2b API,
+// so init checks that restoring it works
+// and otherwise has reset allocate a new hash for every key.
+type keyedBLAKE2b struct {
+	h     hash.Hash
+	u     encoding.BinaryUnmarshaler // h, nil if h cannot be restored
+	size  int
+	state [blake2bStateSize]byte
+}
+
+// init prepares k for keys of keyLen bytes and digests of size bytes.
+func (k *keyedBLAKE2b) init(size, keyLen int) {
+	h, err := blake2b.New(size, nil)
 	if nil != err {
 		panic(AsError(err))
 	}
 
-	if _, err = h.Write(m); nil != err {
-		panic(AsError(err))
+	k.size = size
+	u, ok := h.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return
 	}
 
-	return h.Sum(p)
+	// see blake2b digest.MarshalBinary
+	b := append(k.state[:0], "b2b"...)
+	for i, v := range blake2bIV {
+		if 0 == i {
+			v ^= uint64(size) | uint64(keyLen)<<8 | 1<<16 | 1<<24
+		}
+
+		b = appendUint64BE(b, v)
+	}
+
+	b = appendUint64BE(b, 0)
+	b = appendUint64BE(b, 0)
+	b = append(b, byte(size))
+	b = append(b, make([]byte, blake2b.BlockSize)...) // key
+	b = append(b, blake2b.BlockSize)                  // offset
+
+	k.h, k.u = h, u
+	if !k.restores(keyLen) {
+		k.h, k.u = nil, nil
+	}
+}
+
+// restores reports whether the restored hash
+// matches one keyed by blake2b.New.
+func (k *keyedBLAKE2b) restores(keyLen int) bool {
+	key, m := make([]byte, keyLen), []byte("keyedBLAKE2b")
+	for i := range key {
+		key[i] = byte(i + 1)
+	}
+
+	h, err := blake2b.New(k.size, key)
+	if nil != err {
+		return false
+	}
+
+	h.Write(m)
+	exp := h.Sum(nil)
+
+	block := k.state[blake2bStateSize-1-blake2b.BlockSize:][:blake2b.BlockSize]
+	copy(block, key)
+	err = k.u.UnmarshalBinary(k.state[:])
+	zero(block)
+
+	if nil != err {
+		return false
+	}
+
+	k.h.Write(m)
+	return bytes.Equal(exp, k.h.Sum(nil))
+}
+
+// reset re-keys the hash with key
+// and returns it, ready to be written to.
+// len(key) must be the keyLen passed to init.
+func (k *keyedBLAKE2b) reset(key []byte) hash.Hash {
+	if nil == k.u {
+		h, err := blake2b.New(k.size, key)
+		if nil != err {
+			panic(AsError(err))
+		}
+
+		return h
+	}
+
+	block := k.state[blake2bStateSize-1-blake2b.BlockSize:][:blake2b.BlockSize]
+	zero(block[copy(block, key):])
+
+	err := k.u.UnmarshalBinary(k.state[:])
+	zero(block)
+
+	if nil != err {
+		panic(errBadBLAKE2b)
+	}
+
+	return k.h
+}
+
+// appendUint64BE appends the 64-bit big-endian representation of v to b.
+func appendUint64BE(b []byte, v uint64) []byte {
+	var a [8]byte
+	binary.BigEndian.PutUint64(a[:], v)
+	return append(b, a[:]...)
 }
@@ -0,0 +1,125 @@
+package fpast2l
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+func Test_generateNonce(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 1<<10; i++ {
+		m := randomBytes(make([]byte, i%64))
+		p := randomBytes(make([]byte, 8, 8+nonceSize))
+
+		b := generateNonce(p, m)
+		if exp, act := 8+nonceSize, len(b); exp != act {
+			t.Fatalf("i=%d: expected len(b) = %d, actual %d", i, exp, act)
+		}
+
+		if !bytes.Equal(b[:8], p) {
+			t.Fatalf("i=%d: prefix was modified", i)
+		}
+
+		x := string(b[8:])
+		if seen[x] {
+			t.Fatalf("i=%d: nonce %q repeated", i, hex.EncodeToString(b[8:]))
+		}
+
+		seen[x] = true
+	}
+
+	p, m := make([]byte, 0, nonceSize), []byte("message")
+	n := testing.AllocsPerRun(100, func() { _ = generateNonce(p, m) })
+	if 0 != n && !raceEnabled {
+		t.Errorf("expected 0 allocs, actual %v", n)
+	}
+}
+
+func Test_keyedBLAKE2b(t *testing.T) {
+	t.Parallel()
+
+	k := keyedBLAKE2b{}
+	k.init(nonceSize, nonceSize)
+	if nil == k.u {
+		t.Fatal("expected the hash to be restorable")
+	}
+
+	// a state blake2b does not understand
+	// makes reset fall back to new hashes
+	broken := k
+	broken.state[0] = 'x'
+	if broken.restores(nonceSize) {
+		t.Error("expected a broken state not to restore")
+	}
+
+	broken.u = nil
+	testKeyedBLAKE2b(t, &k)
+	testKeyedBLAKE2b(t, &broken)
+}
+
+func testKeyedBLAKE2b(t *testing.T, k *keyedBLAKE2b) {
+	for i := 0; i < 256; i++ {
+		key := randomBytes(make([]byte, nonceSize))
+		m := randomBytes(make([]byte, i*3))
+
+		h, err := blake2b.New(nonceSize, key)
+		if nil != err {
+			t.Fatal(err)
+		}
+
+		h.Write(m)
+		exp := h.Sum(nil)
+
+		h = k.reset(key)
+		h.Write(m)
+		act := h.Sum(nil)
+
+		if !bytes.Equal(exp, act) {
+			t.Fatalf("i=%d: expected Hex(%q), actual Hex(%q)",
+				i, hex.EncodeToString(exp), hex.EncodeToString(act))
+		}
+	}
+}
+
+func Test_drbg(t *testing.T) {
+	r := drbg{}
+	r.init()
+
+	// read across refills and a reseed
+	b := make([]byte, 3*drbgBufSize+7)
+	prev := make([]byte, len(b))
+	for i := 0; i < 2*drbgReseedSize/len(b); i++ {
+		r.read(b)
+		if bytes.Equal(b, prev) {
+			t.Fatalf("i=%d: output repeated", i)
+		}
+
+		copy(prev, b)
+	}
+
+	for i, c := range r.buf[:r.off] {
+		if 0 != c {
+			t.Fatalf("served byte at %d was not erased", i)
+		}
+	}
+
+	n := testing.AllocsPerRun(100, func() { r.read(b[:nonceSize]) })
+	if 0 != n && !raceEnabled {
+		t.Errorf("expected 0 allocs, actual %v", n)
+	}
+}
+
+func BenchmarkGenerateNonce(b *testing.B) {
+	m := randomBytes(make([]byte, 64))
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		p := make([]byte, 0, nonceSize)
+		for pb.Next() {
+			_ = generateNonce(p[:0], m)
+		}
+	})
+}
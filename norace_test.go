@@ -0,0 +1,7 @@
+//go:build !race
+// +build !race
+
+package fpast2l
+
+// raceEnabled reports whether the race detector is enabled.
+const raceEnabled = false
@@ -0,0 +1,98 @@
+package fpast2l
+
+import "time"
+
+// Op is an operation reported to an Observer.
+type Op int
+
+// Operations.
+const (
+	OpEncrypt Op = iota + 1
+	OpDecrypt
+)
+
+// String implements fmt.Stringer.
+func (op Op) String() string {
+	switch op {
+	case OpEncrypt:
+		return "encrypt"
+	case OpDecrypt:
+		return "decrypt"
+	default:
+		return "invalid"
+	}
+}
+
+// Observation describes a single Engine.Encrypt or Engine.Decrypt call.
+type Observation struct {
+	Op  Op
+	Err error // nil if successful
+
+	Size      int // length of the plaintext payload, 0 if Err is not nil
+	TokenSize int // length of the token
+	Duration  time.Duration
+}
+
+// Kind returns ErrorKind(o.Err), or "ok" if o.Err is nil.
+func (o Observation) Kind() string {
+	if nil == o.Err {
+		return "ok"
+	}
+
+	return ErrorKind(o.Err)
+}
+
+// Observer is notified of every Encrypt and Decrypt call of an Engine
+// it is attached to (see Engine.WithObserver).
+//
+// Observe is called synchronously
+// from the goroutine that called Encrypt or Decrypt,
+// possibly from many goroutines at once.
+// It should return quickly and must not retain o.Err
+// beyond the call unless it is one of the errors of this package.
+type Observer interface {
+	Observe(o Observation)
+}
+
+// ObserverFunc adapts a function to the Observer interface.
+type ObserverFunc func(o Observation)
+
+// Observe implements Observer.
+func (fn ObserverFunc) Observe(o Observation) { fn(o) }
+
+// ErrorKind returns a short, stable name for err
+// that is suitable e.g. as a metric label.
+// Errors not returned by this package are of kind "other".
+func ErrorKind(err error) string {
+	switch err {
+	case nil:
+		return "none"
+	case ErrBadHeader:
+		return "bad_header"
+	case ErrBadEncoding:
+		return "bad_encoding"
+	case ErrBadEncryption:
+		return "bad_encryption"
+	case ErrBadCompression:
+		return "bad_compression"
+	case ErrPayloadTooLarge:
+		return "payload_too_large"
+	case ErrTokenTooLarge:
+		return "token_too_large"
+	case ErrBadPadding:
+		return "bad_padding"
+	case ErrBadWrappedKey:
+		return "bad_wrapped_key"
+	case ErrUnknownKey:
+		return "unknown_key"
+	case ErrNoActiveKey:
+		return "no_active_key"
+	default:
+		return "other"
+	}
+}
+
+// WithObserver returns a copy of Engine
+// that reports every Encrypt and Decrypt call to o.
+// A nil o disables reporting.
+func (eng Engine) WithObserver(o Observer) Engine { eng.obs = o; return eng }
@@ -0,0 +1,81 @@
+package fpast2l
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestEngineWithObserver(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu  sync.Mutex
+		obs []Observation
+	)
+
+	k := randomBytes(make([]byte, KeySize))
+	eng := New(k).WithObserver(ObserverFunc(func(o Observation) {
+		mu.Lock()
+		obs = append(obs, o)
+		mu.Unlock()
+	}))
+
+	s := eng.Encrypt(randomBytes(make([]byte, 64)))
+	if _, err := eng.Decrypt(nil, s); nil != err {
+		t.Fatal(err)
+	}
+
+	_, _ = eng.Decrypt(nil, "v2.local.")
+	bad := rPASTEncrypt(randomBytes(make([]byte, KeySize)),
+		randomBytes(make([]byte, 64)), "")
+	_, _ = eng.Decrypt(nil, bad)
+
+	for i, exp := range [...]struct {
+		op        Op
+		kind      string
+		size      int
+		tokenSize int
+	}{
+		{OpEncrypt, "ok", 64, len(s)},
+		{OpDecrypt, "ok", 64, len(s)},
+		{OpDecrypt, "bad_encoding", 0, len("v2.local.")},
+		{OpDecrypt, "bad_encryption", 0, len(bad)},
+	} {
+		if i >= len(obs) {
+			t.Fatalf("expected %d observations, actual %d", i+1, len(obs))
+		}
+
+		o := obs[i]
+		if o.Op != exp.op || o.Kind() != exp.kind ||
+			o.Size != exp.size || o.TokenSize != exp.tokenSize {
+			t.Errorf("i=%d: expected %v/%s/%d/%d, actual %v/%s/%d/%d", i,
+				exp.op, exp.kind, exp.size, exp.tokenSize,
+				o.Op, o.Kind(), o.Size, o.TokenSize)
+		}
+
+		if o.Duration <= 0 {
+			t.Errorf("i=%d: expected positive duration, actual %v", i, o.Duration)
+		}
+	}
+}
+
+func TestEngineWithObserverAllocs(t *testing.T) {
+	k := randomBytes(make([]byte, KeySize))
+	s := rPASTEncrypt(k, randomBytes(make([]byte, 64)), "")
+	p := make([]byte, 0, 1<<10)
+
+	for i, eng := range [...]Engine{
+		New(k),
+		New(k).WithObserver(ObserverFunc(func(Observation) {})),
+	} {
+		n := testing.AllocsPerRun(100, func() {
+			if _, err := eng.Decrypt(p, s); nil != err {
+				panic(err)
+			}
+		})
+
+		if 0 != n {
+			t.Errorf("i=%d: expected 0 allocs, actual %v", i, n)
+		}
+	}
+}
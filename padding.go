@@ -0,0 +1,81 @@
+package fpast2l
+
+import "crypto/subtle"
+
+// padMarker starts the padding of a payload
+// as described in ISO/IEC 7816-4:
+// a single 0x80 byte followed by zeroes.
+const padMarker = 0x80
+
+var errBadPaddingBuckets = internal("bad padding buckets")
+
+// checkPaddingBuckets panics
+// unless buckets are positive and strictly ascending.
+func checkPaddingBuckets(buckets []int) {
+	for i, n := range buckets {
+		if n <= 0 || (i > 0 && n <= buckets[i-1]) {
+			panic(errBadPaddingBuckets)
+		}
+	}
+}
+
+// paddedSize returns the bucket size for a payload of n bytes
+// (including the padding marker).
+//
+// If buckets is empty, the bucket size is the next power of two.
+// Otherwise it is the smallest bucket that fits n
+// or, if n exceeds every bucket,
+// the next multiple of the largest bucket.
+func paddedSize(n int, buckets []int) int {
+	if 0 == len(buckets) {
+		k := 1
+		for k < n {
+			k <<= 1
+		}
+
+		return k
+	}
+
+	for _, k := range buckets {
+		if n <= k {
+			return k
+		}
+	}
+
+	k := buckets[len(buckets)-1]
+	return (n + k - 1) / k * k
+}
+
+// pad pads b to size bytes,
+// ordering xcap bytes of extra capacity beyond size,
+// and returns the result as r.
+// size must be greater than len(b).
+//
+// Padding is performed in-place
+// and r is backed by the same memory as b
+// if b has capacity for size + xcap bytes.
+func pad(b []byte, size, xcap int) (r []byte) {
+	n := len(b)
+	_, r = extend(b, size-n+xcap)
+	r = r[:size]
+
+	r[n] = padMarker
+	zero(r[n+1:])
+	return r
+}
+
+// unpad strips the padding from b
+// and returns the payload as r,
+// or ErrBadPadding if b is not padded correctly.
+func unpad(b []byte) (r []byte, err error) {
+	i := len(b) - 1
+	for i >= 0 && 0 == b[i] {
+		i--
+	}
+
+	if i < 0 || 1 != subtle.ConstantTimeByteEq(b[i], padMarker) {
+		return nil, ErrBadPadding
+	}
+
+	return b[:i], nil
+}
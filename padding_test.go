@@ -0,0 +1,153 @@
+package fpast2l
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/hex"
+	"testing"
+)
+
+func Test_paddedSize(t *testing.T) {
+	t.Parallel()
+
+	for i, c := range [...]struct {
+		n       int
+		buckets []int
+		exp     int
+	}{
+		{1, nil, 1},
+		{2, nil, 2},
+		{3, nil, 4},
+		{65, nil, 128},
+		{1, []int{64, 256}, 64},
+		{64, []int{64, 256}, 64},
+		{65, []int{64, 256}, 256},
+		{257, []int{64, 256}, 512},
+		{513, []int{64, 256}, 768},
+	} {
+		if act := paddedSize(c.n, c.buckets); act != c.exp {
+			t.Errorf("i=%d: expected %d, actual %d", i, c.exp, act)
+		}
+	}
+}
+
+func Test_pad(t *testing.T) {
+	t.Parallel()
+
+	for i, b := range [...][]byte{
+		nil, {}, {0}, {padMarker}, {0, 0},
+		randomBytes(make([]byte, 63)),
+		randomBytes(make([]byte, 64)),
+	} {
+		size := paddedSize(len(b)+1, nil)
+		b0 := make([]byte, len(b), size+8)
+		copy(b0, b)
+
+		r := pad(b0, size, 8)
+		if len(r) != size {
+			t.Errorf("i=%d: expected len(r) = %d, actual %d", i, size, len(r))
+		}
+
+		if cap(r) < size+8 {
+			t.Errorf("i=%d: expected cap(r) >= %d, actual %d", i, size+8, cap(r))
+		}
+
+		if &r[:1][0] != &b0[:1][0] {
+			t.Errorf("i=%d: b0 was relocated", i)
+		}
+
+		u, err := unpad(r)
+		if nil != err {
+			t.Fatalf("i=%d: %v", i, err)
+		}
+
+		if !bytes.Equal(u, b) {
+			exp := hex.EncodeToString(b)
+			act := hex.EncodeToString(u)
+			t.Errorf("i=%d: expected u = Hex(%q), actual Hex(%q)", i, exp, act)
+		}
+	}
+
+	for i, b := range [...][]byte{
+		nil, {}, {0}, {0, 0}, {padMarker, 1}, {1, 0},
+	} {
+		if _, err := unpad(b); err != ErrBadPadding {
+			t.Errorf("i=%d: expected ErrBadPadding, actual %v", i, err)
+		}
+	}
+}
+
+func TestEnginePadding(t *testing.T) {
+	t.Parallel()
+
+	k := randomBytes(make([]byte, KeySize))
+	f := randomString(16)
+
+	for i, eng := range [...]Engine{
+		New(k).WithFooter(f).WithPadding(),
+		New(k).WithFooter(f).WithPadding(32, 256),
+		New(k).WithFooter(f).WithPadding().
+			WithCompression(flate.DefaultCompression),
+	} {
+		n := -1
+		for j, b := range [...][]byte{
+			randomBytes(make([]byte, 16)),
+			randomBytes(make([]byte, 17)),
+			randomBytes(make([]byte, 31)),
+		} {
+			s := eng.Encrypt(copyBuffer(b))
+			if -1 == n {
+				n = len(s)
+			} else if len(s) != n {
+				t.Errorf("i=%d: expected len(s) = %d, actual %d", i*10+j, n, len(s))
+			}
+
+			r, err := New(k).Decrypt(nil, s)
+			if nil != err {
+				t.Fatalf("i=%d: %v", i*10+j, err)
+			}
+
+			if !bytes.Equal(r, b) {
+				exp := hex.EncodeToString(b)
+				act := hex.EncodeToString(r)
+				t.Errorf("i=%d: expected r = Hex(%q), actual Hex(%q)",
+					i*10+j, exp, act)
+			}
+		}
+	}
+
+	// padded payloads are compressed first
+	eng := New(k).WithPadding().WithCompression(flate.BestCompression)
+	b := permissionsPayload(64)
+	s := eng.Encrypt(copyBuffer(b))
+	if _, rf, err := rPASTDecrypt(k, s); nil != err {
+		t.Fatal(err)
+	} else if exp := flaggedFooters("")[flagDeflate|flagPad]; rf != exp {
+		t.Errorf("expected f = %q, actual %q", exp, rf)
+	}
+
+	if r, err := eng.Decrypt(nil, s); nil != err {
+		t.Fatal(err)
+	} else if !bytes.Equal(r, b) {
+		t.Errorf("expected r = %q, actual %q", b, r)
+	}
+
+	// flagged, but not padded
+	s = rPASTEncrypt(k, []byte{1, 2, 3}, flaggedFooters(f)[flagPad])
+	if _, err := New(k).Decrypt(nil, s); err != ErrBadPadding {
+		t.Errorf("expected ErrBadPadding, actual %v", err)
+	}
+}
+
+func BenchmarkEnginePadding(b *testing.B) {
+	eng := New(randomBytes(make([]byte, KeySize))).WithPadding()
+	B := randomBytes(make([]byte, 32))
+	b0 := make([]byte, len(B), 512)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		copy(b0, B)
+		_ = eng.Encrypt(b0[:len(B)])
+	}
+}
@@ -1,7 +1,5 @@
 package fpast2l
 
-import "unsafe"
-
 const minPAESize = 8 + 8 + headerSize + 8 + nonceSize + 8
 
 var (
@@ -48,6 +46,16 @@ func (p *pae) init(xcap int) {
 	*p = pae(b)
 }
 
+// paeTemplate returns the pre-authentication encoding
+// with a zero nonce and the footer f.
+// Copies of the template only need the nonce filled in.
+func paeTemplate(f string) string {
+	p := pae{}
+	p.init(len(f))
+	p.setFooter(f)
+	return string(p)
+}
+
 // getNonce returns the nonce within pae.
 func (p *pae) getNonce() []byte {
 	p.checkLength()
@@ -78,19 +86,19 @@ func (p *pae) setNonce(x []byte) []byte {
 	return r
 }
 
-// setNonceB64 decodes X
+// setNonceB64 decodes X using c
 // as RFC 4648 sec. 5 Base64 encoding without padding
 // and writes the result to the location with pae.
 //
 // An error is returned if X is invalid.
-func (p *pae) setNonceB64(X string) ([]byte, error) {
+func (p *pae) setNonceB64(X string, c b64Codec) ([]byte, error) {
 	p.checkLength()
 	if b64NonceSize != len(X) {
 		panic(errBadNonceLength)
 	}
 
 	x := p.getNonce()
-	_, err := b64.Decode(x, *(*[]byte)(unsafe.Pointer(&X)))
+	_, err := c.decode(x, X)
 	return x, err
 }
 
@@ -146,8 +154,7 @@ func (p *pae) setFooterB64(F string) ([]byte, error) {
 		b = b[:minPAESize]
 	} else {
 		_, b = extend(b[:minPAESize], k)
-		if _, err := b64.Decode(b[minPAESize:],
-			*(*[]byte)(unsafe.Pointer(&F))); nil != err {
+		if _, err := decodeB64(b[minPAESize:], F); nil != err {
 			return p.setFooter(""), ErrBadEncoding
 		}
 	}
@@ -55,3 +55,17 @@ func Test_paeinit(t *testing.T) {
 		}
 	}
 }
+
+func Test_paeTemplate(t *testing.T) {
+	t.Parallel()
+
+	for i, f := range [...]string{"", randomString(1), randomString(32)} {
+		p := pae{}
+		p.init(len(f))
+		p.setFooter(f)
+
+		if exp, act := string(p), paeTemplate(f); exp != act {
+			t.Errorf("i=%d: expected %q, actual %q", i, exp, act)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build race
+// +build race
+
+package fpast2l
+
+// raceEnabled reports whether the race detector is enabled.
+// sync.Pool randomly drops items under the race detector,
+// which makes allocation counts unreliable.
+const raceEnabled = true
@@ -0,0 +1,237 @@
+// Package remote implements a cipher.AEAD
+// that delegates sealing and opening to a helper process
+// over a stream socket (e.g. a Unix domain socket),
+// so that the encryption key never enters the calling process.
+//
+// The AEAD returned by Dial or NewAEAD can be passed to
+// fpast2l.NewWithAEAD.
+// Serve implements the helper side of the protocol
+// and is meant as a reference backend, e.g. for testing.
+package remote
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/aead/poly1305"
+	"github.com/zrhmn/fpast2l"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	opSeal = 'S'
+	opOpen = 'O'
+
+	statusOK     = 0
+	statusFailed = 1
+
+	// maxFrameSize is the largest nonce, additional data
+	// or text that either side will accept in a single frame.
+	maxFrameSize = 1 << 24
+)
+
+var (
+	le = binary.LittleEndian
+
+	// ErrFrameTooLarge is returned when a peer announces
+	// a frame larger than maxFrameSize.
+	ErrFrameTooLarge = fpast2l.AsError(errors.New("remote: frame too large"))
+
+	// ErrBadStatus is returned when the helper
+	// responds with an unknown status.
+	ErrBadStatus = fpast2l.AsError(errors.New("remote: bad status"))
+
+	// ErrOpenFailed is returned by AEAD.Open
+	// when the helper failed to authenticate the ciphertext.
+	ErrOpenFailed = fpast2l.AsError(errors.New("remote: open failed"))
+
+	// ErrClosed is returned by an AEAD after Close
+	// or after an exchange with the helper failed midway.
+	ErrClosed = fpast2l.AsError(errors.New("remote: connection closed"))
+)
+
+// AEAD is a XChaCha20-Poly1305 cipher.AEAD
+// whose key is held by a helper process on the other end of a connection.
+//
+// AEAD is safe for concurrent use.
+// Requests are serialized over the single underlying connection.
+// If an exchange fails midway, e.g. on an I/O error or a bad frame,
+// the request and response streams are out of step,
+// so the AEAD closes the connection
+// and fails every later request with ErrClosed.
+type AEAD struct {
+	mu     sync.Mutex
+	conn   io.Closer
+	rw     *bufio.ReadWriter
+	closed bool
+}
+
+// Dial connects to the helper process listening at address on network
+// and returns an AEAD using that connection.
+func Dial(network, address string) (*AEAD, error) {
+	conn, err := net.Dial(network, address)
+	if nil != err {
+		return nil, err
+	}
+
+	return NewAEAD(conn), nil
+}
+
+// NewAEAD returns an AEAD speaking to a helper process over conn.
+// The AEAD takes ownership of conn.
+func NewAEAD(conn io.ReadWriteCloser) *AEAD {
+	return &AEAD{
+		conn: conn,
+		rw: bufio.NewReadWriter(
+			bufio.NewReader(conn),
+			bufio.NewWriter(conn),
+		),
+	}
+}
+
+// NonceSize implements cipher.AEAD.
+func (a *AEAD) NonceSize() int { return chacha20poly1305.NonceSizeX }
+
+// Overhead implements cipher.AEAD.
+func (a *AEAD) Overhead() int { return poly1305.TagSize }
+
+// Seal implements cipher.AEAD.
+// It panics if the helper cannot be reached,
+// since cipher.AEAD provides no way to report the failure.
+func (a *AEAD) Seal(dst, nonce, plaintext, ad []byte) []byte {
+	if len(nonce) != a.NonceSize() {
+		panic("remote: bad nonce length passed to Seal")
+	}
+
+	r, err := a.roundTrip(opSeal, dst, nonce, plaintext, ad)
+	if nil != err {
+		panic(fpast2l.AsError(err))
+	}
+
+	return r
+}
+
+// Open implements cipher.AEAD.
+func (a *AEAD) Open(dst, nonce, ciphertext, ad []byte) ([]byte, error) {
+	if len(nonce) != a.NonceSize() {
+		panic("remote: bad nonce length passed to Open")
+	}
+
+	return a.roundTrip(opOpen, dst, nonce, ciphertext, ad)
+}
+
+// Close closes the connection to the helper process,
+// unless a failed exchange already closed it.
+func (a *AEAD) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return nil
+	}
+
+	a.closed = true
+	return a.conn.Close()
+}
+
+// roundTrip sends a request with opcode op
+// and appends the data of the response to dst.
+// If the exchange fails midway, the connection is closed.
+//
+// The request is written out completely before the response is read,
+// so dst may alias text as permitted by cipher.AEAD.
+func (a *AEAD) roundTrip(op byte, dst, nonce, text, ad []byte) ([]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return nil, ErrClosed
+	}
+
+	r, err := a.exchange(op, dst, nonce, text, ad)
+	if nil != err && ErrOpenFailed != err {
+		a.closed = true
+		a.conn.Close()
+	}
+
+	return r, err
+}
+
+// exchange implements roundTrip.
+func (a *AEAD) exchange(op byte, dst, nonce, text, ad []byte) ([]byte, error) {
+	if err := a.rw.WriteByte(op); nil != err {
+		return nil, err
+	}
+
+	for _, p := range [...][]byte{nonce, ad, text} {
+		if err := writeFrame(a.rw.Writer, p); nil != err {
+			return nil, err
+		}
+	}
+
+	if err := a.rw.Flush(); nil != err {
+		return nil, err
+	}
+
+	status, err := a.rw.ReadByte()
+	if nil != err {
+		return nil, err
+	}
+
+	r, err := readFrame(a.rw.Reader, dst)
+	if nil != err {
+		return nil, err
+	}
+
+	switch status {
+	case statusOK:
+		return r, nil
+	case statusFailed:
+		return nil, ErrOpenFailed
+	default:
+		return nil, ErrBadStatus
+	}
+}
+
+// writeFrame writes the length of p followed by p to w.
+func writeFrame(w *bufio.Writer, p []byte) error {
+	var n [4]byte
+	le.PutUint32(n[:], uint32(len(p)))
+	if _, err := w.Write(n[:]); nil != err {
+		return err
+	}
+
+	_, err := w.Write(p)
+	return err
+}
+
+// readFrame reads a frame written by writeFrame from r,
+// appends its contents to p and returns it as b.
+func readFrame(r *bufio.Reader, p []byte) (b []byte, err error) {
+	var n [4]byte
+	if _, err = io.ReadFull(r, n[:]); nil != err {
+		return nil, err
+	}
+
+	k := int(le.Uint32(n[:]))
+	if k > maxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+
+	b = p
+	if cap(b)-len(b) < k {
+		b = make([]byte, len(p), len(p)+k)
+		copy(b, p)
+	}
+
+	b = b[:len(p)+k]
+	if _, err = io.ReadFull(r, b[len(p):]); nil != err {
+		return nil, err
+	}
+
+	return b, nil
+}
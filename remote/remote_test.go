@@ -0,0 +1,133 @@
+package remote
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zrhmn/fpast2l"
+)
+
+func TestAEAD(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "fpast2l-remote")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ln, err := net.Listen("unix", filepath.Join(dir, "aead.sock"))
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	k := randomBytes(make([]byte, fpast2l.KeySize))
+	go Serve(ln, k)
+
+	ci, err := Dial("unix", ln.Addr().String())
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer ci.Close()
+
+	local := fpast2l.New(k).WithFooter("kid")
+	eng := fpast2l.NewWithAEAD(ci).WithFooter("kid")
+
+	for i, b := range [...][]byte{
+		nil, {}, {0},
+		randomBytes(make([]byte, 64)),
+		randomBytes(make([]byte, 1<<10)),
+	} {
+		for j, pair := range [...][2]fpast2l.Engine{
+			{eng, local}, {local, eng}, {eng, eng},
+		} {
+			s := pair[0].Encrypt(copyBuffer(b))
+			r, err := pair[1].Decrypt(nil, s)
+			if nil != err {
+				t.Fatalf("i=%d: %v", i*10+j, err)
+			}
+
+			if !bytes.Equal(r, b) {
+				exp := hex.EncodeToString(b)
+				act := hex.EncodeToString(r)
+				t.Errorf("i=%d: expected r = Hex(%q), actual Hex(%q)",
+					i*10+j, exp, act)
+			}
+		}
+	}
+
+	s := local.Encrypt(randomBytes(make([]byte, 32)))
+	c := byte('A')
+	if 'A' == s[50] {
+		c = 'B'
+	}
+
+	s = s[:50] + string(c) + s[51:] // tamper with the ciphertext
+	if _, err := eng.Decrypt(nil, s); err != fpast2l.ErrBadEncryption {
+		t.Errorf("expected ErrBadEncryption, actual %v", err)
+	}
+}
+
+func TestAEADBrokenExchange(t *testing.T) {
+	t.Parallel()
+
+	c, h := net.Pipe()
+	ci := NewAEAD(c)
+	defer ci.Close()
+
+	done := make(chan error, 1)
+	go func() { // a helper announcing a frame that is too large
+		r := bufio.NewReader(h)
+		if _, err := r.Discard(1 + 3*4 + ci.NonceSize()); nil != err {
+			done <- err
+			return
+		}
+
+		var b [5]byte
+		le.PutUint32(b[1:], maxFrameSize+1)
+		h.Write(append(b[:], "left unread"...))
+
+		_, err := r.ReadByte() // the AEAD must hang up
+		done <- err
+	}()
+
+	nonce := make([]byte, ci.NonceSize())
+	if _, err := ci.Open(nil, nonce, nil, nil); err != ErrFrameTooLarge {
+		t.Errorf("expected ErrFrameTooLarge, actual %v", err)
+	}
+
+	if err := <-done; err != io.EOF {
+		t.Errorf("expected the connection to be closed, actual %v", err)
+	}
+
+	if _, err := ci.Open(nil, nonce, nil, nil); err != ErrClosed {
+		t.Errorf("expected ErrClosed, actual %v", err)
+	}
+
+	if err := ci.Close(); nil != err {
+		t.Errorf("expected nil, actual %v", err)
+	}
+}
+
+func randomBytes(p []byte) []byte {
+	if _, err := rand.Read(p); nil != err {
+		panic(err)
+	}
+
+	return p
+}
+
+func copyBuffer(p []byte) (b []byte) {
+	b = make([]byte, len(p))
+	copy(b, p)
+	return
+}
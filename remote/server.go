@@ -0,0 +1,98 @@
+package remote
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"io"
+	"net"
+
+	"github.com/zrhmn/fpast2l"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Serve accepts connections on ln
+// and answers seal and open requests on each of them
+// using XChaCha20-Poly1305 with the key K.
+// Serve returns when ln.Accept fails, e.g. after ln is closed.
+//
+// Serve panics if len(K) is not exactly fpast2l.KeySize bytes.
+func Serve(ln net.Listener, K []byte) error {
+	if len(K) != fpast2l.KeySize {
+		panic(fpast2l.ErrBadKeySize)
+	}
+
+	ci, err := chacha20poly1305.NewX(K)
+	if nil != err {
+		panic(err)
+	}
+
+	for {
+		conn, err := ln.Accept()
+		if nil != err {
+			return err
+		}
+
+		go func() {
+			defer conn.Close()
+			_ = ServeConn(conn, ci)
+		}()
+	}
+}
+
+// ServeConn answers seal and open requests on conn using ci,
+// until conn is closed by the peer or an error occurs.
+// It returns nil if the peer closed conn between requests.
+func ServeConn(conn io.ReadWriter, ci cipher.AEAD) error {
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	var nonce, ad, text []byte
+	for {
+		op, err := r.ReadByte()
+		if io.EOF == err {
+			return nil
+		} else if nil != err {
+			return err
+		}
+
+		if nonce, err = readFrame(r, nonce[:0]); nil != err {
+			return err
+		}
+
+		if ad, err = readFrame(r, ad[:0]); nil != err {
+			return err
+		}
+
+		if text, err = readFrame(r, text[:0]); nil != err {
+			return err
+		}
+
+		status := byte(statusOK)
+		if len(nonce) != ci.NonceSize() {
+			status, text = statusFailed, text[:0]
+		} else {
+			switch op {
+			case opSeal:
+				text = ci.Seal(text[:0], nonce, text, ad)
+			case opOpen:
+				if text, err = ci.Open(text[:0], nonce, text, ad); nil != err {
+					status, text = statusFailed, text[:0]
+				}
+			default:
+				status, text = statusFailed, text[:0]
+			}
+		}
+
+		if err = w.WriteByte(status); nil != err {
+			return err
+		}
+
+		if err = writeFrame(w, text); nil != err {
+			return err
+		}
+
+		if err = w.Flush(); nil != err {
+			return err
+		}
+	}
+}
@@ -0,0 +1,49 @@
+//go:build purego || safe
+// +build purego safe
+
+package fpast2l
+
+import "strings"
+
+// This file replaces unsafe.go
+// when building with the purego or safe build tag,
+// for environments where package unsafe is not acceptable.
+// Tokens are the same either way;
+// Engine.Encrypt makes one more allocation and copy per token
+// and decoding copies tokens through a stack buffer.
+
+// pureGo reports whether fpast2l was built without package unsafe.
+const pureGo = true
+
+// stringOf returns a copy of b as a string.
+func stringOf(b []byte) string { return string(b) }
+
+// decodeB64 decodes s
+// as RFC 4648 sec. 5 Base64 encoding without padding
+// into dst, as b64.Decode would.
+//
+// s is copied through a stack buffer, in blocks of whole quanta,
+// so that decoding does not allocate.
+// Since the decoder skips newlines,
+// which would break up the quanta,
+// s is copied in one piece if it contains any.
+func decodeB64(dst []byte, s string) (n int, err error) {
+	if strings.IndexByte(s, '\n') >= 0 || strings.IndexByte(s, '\r') >= 0 {
+		return b64.Decode(dst, []byte(s))
+	}
+
+	var buf [256]byte // multiple of 4, i.e. whole quanta
+
+	for 0 != len(s) {
+		k := copy(buf[:], s)
+		m, err := b64.Decode(dst[n:], buf[:k])
+		n += m
+		if nil != err {
+			return n, err
+		}
+
+		s = s[k:]
+	}
+
+	return n, nil
+}
@@ -0,0 +1,169 @@
+// Package shamir implements Shamir's secret sharing over GF(2^8),
+// e.g. to split an fpast2l key among operators
+// so that no single one of them can reconstruct it.
+//
+// A secret is split byte-wise into shares,
+// each one byte longer than the secret:
+// the last byte is the x coordinate of the share.
+// Field arithmetic does not branch on or index by secret data.
+package shamir
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+
+	"github.com/zrhmn/fpast2l"
+)
+
+var (
+	// ErrBadParameters is returned by Split
+	// unless 2 <= threshold <= n <= 255 and the secret is non-empty.
+	ErrBadParameters = fpast2l.AsError(errors.New("shamir: bad parameters"))
+
+	// ErrBadShares is returned by Combine
+	// if fewer than two shares are given,
+	// their lengths differ
+	// or their x coordinates are zero or not distinct.
+	ErrBadShares = fpast2l.AsError(errors.New("shamir: bad shares"))
+
+	// ErrBadShare is returned by ParseShare
+	// if its argument is not a hex encoded share.
+	ErrBadShare = fpast2l.AsError(errors.New("shamir: bad share"))
+)
+
+// Split splits secret into n shares,
+// any threshold of which can be combined to reconstruct it.
+func Split(secret []byte, n, threshold int) ([][]byte, error) {
+	if threshold < 2 || threshold > n || n > 255 || 0 == len(secret) {
+		return nil, ErrBadParameters
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][len(secret)] = byte(i + 1)
+	}
+
+	// coefficients of one polynomial per byte of secret,
+	// the constant term being the secret byte
+	coef := make([]byte, threshold)
+	defer zero(coef)
+
+	for j, s := range secret {
+		if _, err := rand.Read(coef[1:]); nil != err {
+			return nil, fpast2l.AsError(err)
+		}
+
+		coef[0] = s
+		for _, share := range shares {
+			share[j] = eval(coef, share[len(secret)])
+		}
+	}
+
+	return shares, nil
+}
+
+// Combine reconstructs a secret from shares.
+// If fewer shares are given than the threshold they were split with,
+// or shares of different secrets are mixed,
+// the result is not the secret; Combine cannot detect that.
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, ErrBadShares
+	}
+
+	m := len(shares[0])
+	xs := make([]byte, len(shares))
+	for i, share := range shares {
+		if len(share) != m || m < 2 || 0 == share[m-1] {
+			return nil, ErrBadShares
+		}
+
+		xs[i] = share[m-1]
+		for _, x := range xs[:i] {
+			if x == xs[i] {
+				return nil, ErrBadShares
+			}
+		}
+	}
+
+	// Lagrange basis polynomials at 0
+	ls := make([]byte, len(shares))
+	for i, xi := range xs {
+		l := byte(1)
+		for j, xj := range xs {
+			if i != j {
+				l = mul(l, div(xj, xj^xi))
+			}
+		}
+
+		ls[i] = l
+	}
+
+	secret := make([]byte, m-1)
+	for k := range secret {
+		var s byte
+		for i, share := range shares {
+			s ^= mul(share[k], ls[i])
+		}
+
+		secret[k] = s
+	}
+
+	return secret, nil
+}
+
+// FormatShare returns the hex encoding of share.
+func FormatShare(share []byte) string { return hex.EncodeToString(share) }
+
+// ParseShare decodes a share formatted by FormatShare.
+func ParseShare(s string) ([]byte, error) {
+	share, err := hex.DecodeString(s)
+	if nil != err || len(share) < 2 {
+		return nil, ErrBadShare
+	}
+
+	return share, nil
+}
+
+// eval evaluates the polynomial with coefficients coef at x
+// (Horner's method).
+func eval(coef []byte, x byte) (y byte) {
+	for i := len(coef) - 1; i >= 0; i-- {
+		y = mul(y, x) ^ coef[i]
+	}
+
+	return
+}
+
+// mul multiplies a and b in GF(2^8)
+// modulo x^8 + x^4 + x^3 + x + 1, in constant time.
+func mul(a, b byte) (r byte) {
+	for i := 0; i < 8; i++ {
+		r ^= -(b & 1) & a
+		b >>= 1
+		a = a<<1 ^ -(a>>7)&0x1b
+	}
+
+	return
+}
+
+// div divides a by b != 0 in GF(2^8), in constant time.
+func div(a, b byte) byte {
+	// b^-1 = b^254
+	r, p := byte(1), b
+	for i := 0; i < 7; i++ {
+		p = mul(p, p) // b^2, b^4, ..., b^128
+		r = mul(r, p)
+	}
+
+	return mul(a, r)
+}
+
+// zero overwrites b with zeroes.
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
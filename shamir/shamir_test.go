@@ -0,0 +1,112 @@
+package shamir
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"github.com/zrhmn/fpast2l"
+)
+
+func TestSplitCombine(t *testing.T) {
+	t.Parallel()
+
+	secret := make([]byte, fpast2l.KeySize)
+	if _, err := rand.Read(secret); nil != err {
+		t.Fatal(err)
+	}
+
+	for i, c := range [...]struct{ n, threshold int }{
+		{2, 2}, {3, 2}, {5, 3}, {6, 6}, {255, 2},
+	} {
+		shares, err := Split(secret, c.n, c.threshold)
+		if nil != err {
+			t.Fatalf("i=%d: %v", i, err)
+		}
+
+		if len(shares) != c.n {
+			t.Fatalf("i=%d: expected %d shares, actual %d", i, c.n, len(shares))
+		}
+
+		// every window of threshold shares, in reverse
+		for j := 0; j+c.threshold <= c.n; j++ {
+			var sub [][]byte
+			for k := j + c.threshold - 1; k >= j; k-- {
+				s, err := ParseShare(FormatShare(shares[k]))
+				if nil != err {
+					t.Fatalf("i=%d: %v", i, err)
+				}
+
+				sub = append(sub, s)
+			}
+
+			r, err := Combine(sub)
+			if nil != err {
+				t.Fatalf("i=%d, j=%d: %v", i, j, err)
+			}
+
+			if !bytes.Equal(r, secret) {
+				t.Errorf("i=%d, j=%d: expected %x, actual %x", i, j, secret, r)
+			}
+
+			if r, _ := Combine(sub[1:]); 1 < len(sub[1:]) && bytes.Equal(r, secret) {
+				t.Errorf("i=%d, j=%d: expected fewer shares to fail", i, j)
+			}
+		}
+	}
+}
+
+func TestSplitCombineErrors(t *testing.T) {
+	t.Parallel()
+
+	secret := []byte("secret")
+	for i, c := range [...]struct {
+		secret       []byte
+		n, threshold int
+	}{
+		{secret, 3, 1}, {secret, 2, 3}, {secret, 256, 2}, {nil, 3, 2},
+	} {
+		if _, err := Split(c.secret, c.n, c.threshold); err != ErrBadParameters {
+			t.Errorf("i=%d: expected ErrBadParameters, actual %v", i, err)
+		}
+	}
+
+	shares, err := Split(secret, 3, 2)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	for i, sub := range [...][][]byte{
+		nil,
+		shares[:1],
+		{shares[0], shares[0]},
+		{shares[0], shares[1][1:]},
+		{shares[0], append(append([]byte(nil), shares[1][:6]...), 0)},
+	} {
+		if _, err := Combine(sub); err != ErrBadShares {
+			t.Errorf("i=%d: expected ErrBadShares, actual %v", i, err)
+		}
+	}
+
+	for i, s := range [...]string{"", "ab", "xyz0"} {
+		if _, err := ParseShare(s); err != ErrBadShare {
+			t.Errorf("i=%d: expected ErrBadShare, actual %v", i, err)
+		}
+	}
+}
+
+func Test_mul(t *testing.T) {
+	t.Parallel()
+
+	// every non-zero element has an inverse
+	for a := 1; a < 256; a++ {
+		if r := mul(byte(a), div(1, byte(a))); 1 != r {
+			t.Errorf("a=%d: expected a * a^-1 = 1, actual %d", a, r)
+		}
+	}
+
+	// FIPS 197, sec. 4.2
+	if r := mul(0x57, 0x83); 0xc1 != r {
+		t.Errorf("expected {57} * {83} = {c1}, actual {%02x}", r)
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !purego && !safe
+// +build !purego,!safe
+
+package fpast2l
+
+import "unsafe"
+
+// pureGo reports whether fpast2l was built without package unsafe
+// (see safe.go).
+const pureGo = false
+
+// bytesOf returns a byte slice backed by the same memory as s.
+// The returned slice must never be written to.
+func bytesOf(s string) []byte { return unsafe.Slice(unsafe.StringData(s), len(s)) }
+
+// stringOf returns a string backed by the same memory as b.
+// b must never be written to afterwards.
+func stringOf(b []byte) string { return unsafe.String(unsafe.SliceData(b), len(b)) }
+
+// decodeB64 decodes s
+// as RFC 4648 sec. 5 Base64 encoding without padding
+// into dst, as b64.Decode would,
+// without copying s.
+func decodeB64(dst []byte, s string) (int, error) {
+	return b64.Decode(dst, bytesOf(s))
+}